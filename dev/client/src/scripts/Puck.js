@@ -84,9 +84,9 @@ export default class Puck {
     }
 
     update() {
-        // update puck only if it is (an offline game) OR (if it is an online game, provided mainPlayer is the host)
-        // Reason: if mainPlayer is not host, puck must be updated using remote state received via web socket connection
-        if(!state.isOnlineGame || (state.isOnlineGame && state.isHost)) {
+        // update puck only in offline games
+        // Reason: in online games the server simulates the puck, which is updated using the snapshots received via web socket connection
+        if(!state.isOnlineGame) {
             // Slow down the puck using friction
             this.xVel *= this.#friction;
             this.yVel *= this.#friction;
@@ -0,0 +1,214 @@
+// Compact binary encoding of web socket messages, mirroring the server's codec package (dev/server/codec).
+// A message is a version byte followed by an object value; see codec.go for the layout of values.
+
+export const CODEC_VERSION = 2; // latest version, the server may answer the handshake with an older one
+
+const TAG_NULL = 0;
+const TAG_FALSE = 1;
+const TAG_TRUE = 2;
+const TAG_INT = 3;
+const TAG_FLOAT = 4;
+const TAG_STRING = 5;
+const TAG_KNOWN_STRING = 6;
+const TAG_ARRAY = 7;
+const TAG_OBJECT = 8;
+
+const MAX_DEPTH = 16;
+const MAX_INT = 2 ** 52 - 1; // larger ints are sent as floats, so that their zigzag encoding stays a safe integer
+
+// must hold the same entries in the same order as dictionary.go; each version uses the first DICTIONARY_SIZES[version - 1]
+const dictionary = [
+    // version 1 fields
+    "channel", "userName", "team", "striker", "isHost", "isReady", "allReady", "isSuccess", "message",
+    "playerXPos", "playerYPos", "playerXVel", "playerYVel", "puckXPos", "puckYPos", "puckXVel", "puckYVel",
+    "leftScore", "rightScore", "tick", "phase", "states", "serverTime", "kickoffTime", "reason",
+    "text", "quickChat", "isSpectator", "messages", "difficulty", "mode", "isLocked", "isBanned",
+    "fromUserName", "toUserName", "userNames", "pausesLeft", "autoResumeTime", "gracePeriod", "timeout",
+    "roomName", "ratings", "winner", "duration", "players", "replayId",
+    // version 1 channels
+    "handshake", "state", "snapshot", "ready", "start", "countdown", "pause", "resume", "matchOver",
+    "chat", "chatHistory", "error", "memberLeft", "reassignHost", "hostTransferred", "kicked",
+    "memberKicked", "memberBanned", "roomLocked", "roomClosed", "switch", "memberSwitched",
+    "memberDisconnected", "memberReconnected", "addBot", "removeBot", "botAdded", "queue", "cancelQueue",
+    "queued", "queueCancelled", "queueTimeout", "matchFound", "kick", "lock", "transferHost",
+    // version 1 values
+    "left", "right", "lobby", "playing", "paused", "matchStart", "goal", "stuckPuck",
+    "easy", "medium", "hard", "1v1", "2v2",
+    // version 2 delta snapshots
+    "ack", "baseTick", "removed",
+    // version 2 time sync
+    "timeSync", "clientTime", "rtt", "jitter",
+    // version 2 state sequencing
+    "seq",
+    // version 2 lag compensation
+    "hit", "isAccepted",
+];
+
+const DICTIONARY_SIZES = [95, 105];
+
+const dictionaryIndices = new Map(dictionary.map((entry, idx) => [entry, idx]));
+
+const textEncoder = new TextEncoder();
+const textDecoder = new TextDecoder("utf-8", {fatal: true});
+
+// encodes an object the way JSON.stringify() would see it, e.g. skipping undefined fields
+export function encode(payload, version = CODEC_VERSION) {
+    if (version < 1 || CODEC_VERSION < version) throw new Error("Unsupported codec version");
+
+    const bytes = [version];
+    writeValue(bytes, payload, 0, DICTIONARY_SIZES[version - 1]);
+    return new Uint8Array(bytes);
+}
+
+export function decode(buffer) {
+    const bytes = new Uint8Array(buffer);
+    if (bytes.length < 2 || bytes[0] < 1 || CODEC_VERSION < bytes[0] || bytes[1] !== TAG_OBJECT) {
+        throw new Error("Unsupported binary message");
+    }
+
+    const reader = {bytes, pos: 1, dictionarySize: DICTIONARY_SIZES[bytes[0] - 1]};
+    const payload = readValue(reader, 0);
+    if (reader.pos !== reader.bytes.length) throw new Error("Malformed binary message");
+    return payload;
+}
+
+function writeValue(bytes, value, depth, dictionarySize) {
+    if (MAX_DEPTH < depth) throw new Error("Message is nested too deeply");
+
+    if (value === null || value === undefined || (typeof value === "number" && !Number.isFinite(value))) {
+        bytes.push(TAG_NULL);
+    } else if (typeof value === "boolean") {
+        bytes.push(value ? TAG_TRUE : TAG_FALSE);
+    } else if (typeof value === "number" && Number.isInteger(value) && Math.abs(value) <= MAX_INT) {
+        bytes.push(TAG_INT);
+        writeUvarint(bytes, 0 <= value ? 2 * value : -2 * value - 1);
+    } else if (typeof value === "number") {
+        bytes.push(TAG_FLOAT);
+        const view = new DataView(new ArrayBuffer(8));
+        view.setFloat64(0, value);
+        bytes.push(...new Uint8Array(view.buffer));
+    } else if (typeof value === "string" && dictionaryIndices.get(value) < dictionarySize) {
+        bytes.push(TAG_KNOWN_STRING);
+        writeUvarint(bytes, dictionaryIndices.get(value));
+    } else if (typeof value === "string") {
+        bytes.push(TAG_STRING);
+        writeString(bytes, value);
+    } else if (Array.isArray(value)) {
+        bytes.push(TAG_ARRAY);
+        writeUvarint(bytes, value.length);
+        for (const elem of value) writeValue(bytes, elem, depth + 1, dictionarySize);
+    } else {
+        const keys = Object.keys(value).filter(key => value[key] !== undefined && typeof value[key] !== "function");
+        bytes.push(TAG_OBJECT);
+        writeUvarint(bytes, keys.length);
+        for (const key of keys) {
+            if (dictionaryIndices.get(key) < dictionarySize) {
+                writeUvarint(bytes, dictionaryIndices.get(key) + 1);
+            } else {
+                writeUvarint(bytes, 0);
+                writeString(bytes, key);
+            }
+            writeValue(bytes, value[key], depth + 1, dictionarySize);
+        }
+    }
+}
+
+function writeUvarint(bytes, value) {
+    // plain arithmetic instead of bitwise operators, which truncate to 32 bits
+    while (128 <= value) {
+        bytes.push(value % 128 + 128);
+        value = Math.floor(value / 128);
+    }
+    bytes.push(value);
+}
+
+function writeString(bytes, value) {
+    const encoded = textEncoder.encode(value);
+    writeUvarint(bytes, encoded.length);
+    bytes.push(...encoded);
+}
+
+function readValue(reader, depth) {
+    if (MAX_DEPTH < depth || reader.bytes.length <= reader.pos) throw new Error("Malformed binary message");
+
+    const tag = reader.bytes[reader.pos++];
+    switch (tag) {
+        case TAG_NULL:
+            return null;
+        case TAG_FALSE:
+            return false;
+        case TAG_TRUE:
+            return true;
+        case TAG_INT: {
+            const start = reader.pos;
+            const zigzag = readUvarint(reader);
+            if (Number.MAX_SAFE_INTEGER < zigzag) return readBigInt(reader.bytes, start);
+            return zigzag % 2 === 0 ? zigzag / 2 : -(zigzag + 1) / 2;
+        }
+        case TAG_FLOAT: {
+            if (reader.bytes.length < reader.pos + 8) throw new Error("Malformed binary message");
+            const value = new DataView(reader.bytes.buffer, reader.bytes.byteOffset + reader.pos, 8).getFloat64(0);
+            reader.pos += 8;
+            return value;
+        }
+        case TAG_STRING:
+            return readString(reader);
+        case TAG_KNOWN_STRING:
+            return knownString(reader, readUvarint(reader));
+        case TAG_ARRAY: {
+            const count = readUvarint(reader);
+            const value = [];
+            for (let i = 0; i < count; i++) value.push(readValue(reader, depth + 1));
+            return value;
+        }
+        case TAG_OBJECT: {
+            const count = readUvarint(reader);
+            const value = {};
+            for (let i = 0; i < count; i++) {
+                const keyRef = readUvarint(reader);
+                const key = keyRef === 0 ? readString(reader) : knownString(reader, keyRef - 1);
+                value[key] = readValue(reader, depth + 1);
+            }
+            return value;
+        }
+        default:
+            throw new Error("Malformed binary message");
+    }
+}
+
+function readUvarint(reader) {
+    const start = reader.pos;
+    let value = 0;
+    let multiplier = 1;
+    while (reader.pos < reader.bytes.length && reader.pos - start < 10) {
+        const byte = reader.bytes[reader.pos++];
+        value += (byte % 128) * multiplier;
+        if (byte < 128) return value;
+        multiplier *= 128;
+    }
+    throw new Error("Malformed binary message");
+}
+
+// decodes the zigzag uvarint at start exactly, for ints beyond the precision of readUvarint()
+function readBigInt(bytes, start) {
+    let zigzag = 0n;
+    for (let pos = start, shift = 0n; ; pos++, shift += 7n) {
+        zigzag += BigInt(bytes[pos] % 128) << shift;
+        if (bytes[pos] < 128) break;
+    }
+    return Number(zigzag % 2n === 0n ? zigzag / 2n : -(zigzag + 1n) / 2n);
+}
+
+function readString(reader) {
+    const length = readUvarint(reader);
+    if (reader.bytes.length < reader.pos + length) throw new Error("Malformed binary message");
+
+    const value = textDecoder.decode(reader.bytes.subarray(reader.pos, reader.pos + length));
+    reader.pos += length;
+    return value;
+}
+
+function knownString(reader, idx) {
+    if (reader.dictionarySize <= idx) throw new Error("Malformed binary message");
+    return dictionary[idx];
+}
@@ -20,7 +20,7 @@ import {
     Y_GOAL_START_FRACTION
 } from "./global.js";
 import {closeModal, drawTextAtCanvasCenter, hide, hideAllMenus, incrementScore, show, showToast} from "./util.js";
-import {resetPostDisconnect, sendRemoteState} from "./online.js";
+import {reportHit, resetPostDisconnect, sendRemoteState} from "./online.js";
 import {onPauseUsingDoubleClick, onPauseUsingKeyPress} from "./handlers.js";
 import {playSound} from "./audio.js";
 
@@ -194,6 +194,12 @@ export function handleCollisions() {
     handleAiPlayerBoardCollisions();
     if(state.isGoal) return;
 
+    // in online games the server bounces the puck and scores goals, the client only reports hits it sees
+    if(state.isOnlineGame) {
+        handlePuckPlayerCollisions();
+        return;
+    }
+
     // Collisions involving puck
     handlePuckBoardCollisions();
     handlePuckPlayerCollisions();
@@ -281,6 +287,11 @@ export function handlePuckPlayerCollisions() {
         didPlayerCollisionOccur = true;
         player.prevCollisionTimestamp = window.performance.now();
 
+        if(state.isOnlineGame) {
+            if(player === state.mainPlayer) reportHit();
+            continue;
+        }
+
         // update velocities
         const cos = dx/distance;
         const sin = dy/distance;
@@ -17,9 +17,17 @@ export const MAX_USERS_PER_TEAM = 2;
 export const TRUNCATE_FLOAT_PRECISION = 3;
 export const TRUNCATE_FLOAT_FACTOR = Math.pow(10, TRUNCATE_FLOAT_PRECISION);
 export const ONLINE_FPS = 60;
-export const webSocketChannels = ["handshake", "memberLeft", "reassignHost", "state"];
+export const SNAPSHOT_HISTORY_SIZE = 64; // snapshots kept to apply delta snapshots to, same as the server's deltaHistorySize
+export const TIME_SYNC_BURST_SIZE = 5; // time sync requests sent right after the game starts
+export const TIME_SYNC_BURST_INTERVAL = 200; // measured in milliseconds
+export const TIME_SYNC_INTERVAL = 10_000; // measured in milliseconds
+export const TIME_SYNC_SAMPLE_COUNT = 8; // most recent samples, of which the one with the lowest round trip sets the clock offset
+export const webSocketChannels = ["handshake", "memberLeft", "reassignHost", "hostTransferred", "kicked", "memberKicked", "memberBanned", "roomLocked", "switch", "memberSwitched", "addBot", "removeBot", "botAdded", "queue", "cancelQueue", "queued", "queueCancelled", "queueTimeout", "matchFound", "matchFailed", "state", "snapshot", "ack", "timeSync", "hit", "ready", "start", "countdown", "pause", "resume", "matchOver", "chat", "chatHistory", "error"];
 export const WEBSOCKET_SERVER_TIMEOUT = 60_000; // measured in milliseconds
 export const WEBSOCKET_CLIENT_TIMEOUT = 60_000; // measured in milliseconds
+export const RECONNECT_BASE_DELAY = 500; // delay before the first reconnection attempt, doubled after each failed one, measured in milliseconds
+export const RECONNECT_MAX_DELAY = 8000; // measured in milliseconds
+export const RECONNECT_GRACE_PERIOD = 30_000; // how long the server holds the slot of a disconnected user, measured in milliseconds
 export const webSocketErrors = {
     clientError: { code: 3001, reason: "Client-side error" },
     serverInactivity: { code: 3002, reason: "Server inactivity timeout" },
@@ -111,21 +119,35 @@ export const state = {
         playerYPos: 0,
         playerXVel: 0,
         playerYVel: 0,
-        puckXPos: 0,
-        puckYPos: 0,
-        puckXVel: 0,
-        puckYVel: 0,
-        leftScore: 0,
-        rightScore: 0,
+        seq: 0, // numbers the states sent since the game started, so that the server can drop stale ones
     },
+    remoteSeqs: new Map(), // latest applied sequence number of each remote player, keyed by user name
     // metrics
     connTimeoutMetrics: {
         prevMsgTimestamp: 0,
         intervalId: -1,
     },
+    clock: {
+        offset: 0, // server time minus local performance.now() time, measured in milliseconds
+        rtt: 0, // round trip of the sample that set offset, measured in milliseconds
+        samples: [], // recent time sync samples of the form {rtt, offset}
+        timeoutIds: [],
+        intervalId: -1,
+    },
+    reconnect: {
+        isReconnecting: false,
+        attempt: 0,
+        startTimestamp: 0,
+        timeoutId: -1,
+    },
     // online variables
     webSocketConn: null,
     userName: null,
+    sessionToken: null, // resumes the session on a new connection if the current one is lost
+    isLeaving: false, // set when the user leaves the game on purpose, so that closing the connection does not reconnect
+    kickoffTimeoutId: -1, // starts the next round locally when the server releases the puck
+    protocol: 0, // binary codec version negotiated during the handshake, 0 for json
+    snapshots: new Map(), // states of recently applied snapshots keyed by tick, which delta snapshots are based on
     isOnlineGame: false,
     isHost: false,
     // == Offline ==
@@ -1,6 +1,6 @@
 import {$createRoomMenu, $fullscreenToggles, $homeMenu, $joinRoomMenu, $masterVolumeSlider, $muteToggles, $offlineMenu, $onlineMenu, $pauseMenu, $rotateScreenPopup, $settingsMenu, createRoomPlayerTypeSelector, createRoomStrikerSelector, createRoomTeamSelector, joinRoomPlayerTypeSelector, joinRoomStrikerSelector, joinRoomTeamSelector, state} from "./global.js";
 import {clamp, closeModal, hide, show, startLoading, stopLoading} from "./util.js";
-import {connectUsingUserName, createRoom, getRoomList, joinRoom, resetPostDisconnect} from "./online.js";
+import {connectUsingUserName, createRoom, getRoomList, joinRoom, resetPostDisconnect, sendPauseRequest} from "./online.js";
 import {exitGame, resizeBoard, startGameLoop} from "./game.js";
 import {fxGain, masterGain, musicGain, playSound} from "./audio.js";
 import {getSvg} from "./svg.js";
@@ -176,18 +176,26 @@ export function onPauseUsingKeyPress(event) {
     if (state.isPaused) {
         onResume({target: $pauseMenu});
     } else {
-        playSound("buttonPress", false);
-
-        state.isPaused = true;
-        show($pauseMenu);
-        $pauseMenu.showModal();
-        document.activeElement.blur();
+        onPause();
     }
 }
 
 export function onPauseUsingDoubleClick() {
+    onPause();
+}
+
+// online matches are paused for everyone by the server, so the pause menu only opens once the server says so
+function onPause() {
     playSound("buttonPress", false);
 
+    if (state.isOnlineGame) {
+        sendPauseRequest(true);
+    } else {
+        openPauseMenu();
+    }
+}
+
+export function openPauseMenu() {
     state.isPaused = true;
     show($pauseMenu);
     $pauseMenu.showModal();
@@ -195,14 +203,24 @@ export function onPauseUsingDoubleClick() {
 }
 
 export function onResume(event) {
-    closeModal(event.target);
+    if (state.isOnlineGame) {
+        sendPauseRequest(false);
+        return;
+    }
+
+    closePauseMenu(event.target);
+    startGameLoop();
+}
+
+export function closePauseMenu($element) {
+    closeModal($element);
     hide($pauseMenu);
     state.isPaused = false;
-    startGameLoop();
 }
 
 export function onExit() {
-    if(state.isOnlineGame) {
+    if(state.isOnlineGame && !state.reconnect.isReconnecting) {
+        state.isLeaving = true;
         state.webSocketConn.close();
     } else {
         exitGame();
@@ -1,9 +1,10 @@
-import {$canvas, $createRoomMenu, $joinRoomMenu, $leftScore, $message, $onlineMenu, $rightScore, $scores, domain, IS_DEV_MODE, IS_PROD, MAX_ROOM_NAME_LENGTH, MAX_USERNAME_LENGTH, MAX_USERS_PER_ROOM, ONLINE_FPS, state, WEBSOCKET_CLIENT_TIMEOUT, webSocketErrors} from "./global.js";
+import {$canvas, $createRoomMenu, $joinRoomMenu, $leftScore, $message, $onlineMenu, $pauseMenu, $rightScore, $scores, domain, IS_DEV_MODE, IS_PROD, MAX_ROOM_NAME_LENGTH, MAX_USERNAME_LENGTH, MAX_USERS_PER_ROOM, ONLINE_FPS, RECONNECT_BASE_DELAY, RECONNECT_GRACE_PERIOD, RECONNECT_MAX_DELAY, SNAPSHOT_HISTORY_SIZE, state, TIME_SYNC_BURST_INTERVAL, TIME_SYNC_BURST_SIZE, TIME_SYNC_INTERVAL, TIME_SYNC_SAMPLE_COUNT, WEBSOCKET_CLIENT_TIMEOUT, webSocketErrors} from "./global.js";
 import {capitalizeFirstLetter, hideAllMenus, getSignificantFloatDigits, safeExtractScore, setScore, show, showToast, retrieveFloatFromSignificantDigits} from "./util.js";
-import {onClickJoinableRoom, onPauseUsingDoubleClick, onPauseUsingKeyPress} from "./handlers.js";
+import {closePauseMenu, onClickJoinableRoom, onPauseUsingDoubleClick, onPauseUsingKeyPress, openPauseMenu} from "./handlers.js";
 import Player from "./Player.js";
 import {exitGame, startNewRound} from "./game.js";
 import {playSound} from "./audio.js";
+import {CODEC_VERSION, decode, encode} from "./codec.js";
 
 export function connectUsingUserName() {
     return new Promise((resolve) => {
@@ -28,14 +29,16 @@ export function connectUsingUserName() {
             return;
         }
 
-        const protocol = IS_PROD ? "wss" : "ws";
-        state.webSocketConn = new WebSocket(`${protocol}://${domain}/user`);
+        state.webSocketConn = new WebSocket(webSocketUrl());
+        state.webSocketConn.binaryType = "arraybuffer";
 
         state.webSocketConn.onopen = () => {
             if (IS_DEV_MODE) console.log("Web socket connection established");
             state.webSocketConn.send(JSON.stringify({
                 channel: "handshake",
                 userName: $userNameTxtInput.value.trim(),
+                protocol: CODEC_VERSION,
+                deltas: true,
             }));
             if (IS_DEV_MODE) console.log("Sent web socket message on 'handshake' channel");
         }
@@ -43,7 +46,7 @@ export function connectUsingUserName() {
         state.webSocketConn.onmessage = (event) => {
             if (IS_DEV_MODE) console.log("Received web socket message during handshake");
 
-            const payload = JSON.parse(event.data);
+            const payload = parseMessage(event.data);
             if (payload.channel !== "handshake") {
                 if (IS_DEV_MODE) console.error("Received web socket message from invalid channel during handshake");
                 state.webSocketConn.close(webSocketErrors.wrongChannel.code, webSocketErrors.wrongChannel.reason);
@@ -54,6 +57,8 @@ export function connectUsingUserName() {
 
             if (payload.isSuccess) {
                 state.userName = $userNameTxtInput.value.trim();
+                state.sessionToken = payload.sessionToken ?? null;
+                state.protocol = payload.protocol ?? 0;
                 state.webSocketConn.onmessage = null;
                 resolve();
                 return;
@@ -89,8 +94,12 @@ export function startOnlineGame(team, strikerIdx, playerType) {
     state.isOnlineGame = true;
     state.isPaused = false;
     state.fps = ONLINE_FPS;
+    state.snapshots.clear();
+    state.remoteSeqs.clear();
+    state.remoteState.seq = 0;
 
     startConnectionTimeoutInterval();
+    startTimeSyncInterval();
 
     hideAllMenus();
     show($canvas);
@@ -105,9 +114,22 @@ export function startOnlineGame(team, strikerIdx, playerType) {
     state.mainPlayer.strikerIdx = strikerIdx;
     state.mainPlayer.type = playerType;
 
+    attachGameHandlers();
+
+    sendControlMessage({channel: "ready", isReady: true});
+    startNewRound();
+}
+
+function webSocketUrl() {
+    const protocol = IS_PROD ? "wss" : "ws";
+    return `${protocol}://${domain}/user`;
+}
+
+// handles messages of the room the user is in, and reconnects if the connection is lost
+function attachGameHandlers() {
     state.webSocketConn.onmessage = (event) => {
         state.connTimeoutMetrics.prevMsgTimestamp = window.performance.now();
-        const payload = JSON.parse(event.data);
+        const payload = parseMessage(event.data);
 
         switch (payload.channel) {
             case "memberLeft": {
@@ -120,6 +142,7 @@ export function startOnlineGame(team, strikerIdx, playerType) {
                     break;
                 }
 
+                state.remoteSeqs.delete(payload.userName);
                 if (playerThatLeft !== null) {
                     playerThatLeft.removeFromBoard();
                     showToast(`Player ${playerThatLeft.name} left the room`);
@@ -133,41 +156,100 @@ export function startOnlineGame(team, strikerIdx, playerType) {
             }
             break;
 
-            case "state": {
-                if (IS_DEV_MODE) console.log(`Received web socket message on 'state' channel. Remote state originated from remote user ${payload.userName}`);
+            case "hostTransferred": {
+                state.isHost = payload.toUserName === state.userName;
+                if (state.isHost) showToast('You are now the host');
+            }
+            break;
+
+            case "kicked": {
+                showToast(payload.isBanned ? "You were banned from the room" : "You were kicked from the room");
+                state.isLeaving = true;
+                state.webSocketConn.close();
+            }
+            break;
+
+            case "memberSwitched": {
+                if (payload.userName === state.userName) {
+                    state.mainPlayer.team = payload.team;
+                    state.mainPlayer.strikerIdx = payload.striker;
+                    break;
+                }
 
-                let found = false;
+                // next snapshot adds the player back on their new side
                 for (const player of state.players) {
-                    if(player.name !== payload.userName || player === state.mainPlayer) continue;
+                    if (player.name !== payload.userName || player === state.mainPlayer) continue;
+                    player.removeFromBoard();
+                    break;
+                }
+            }
+            break;
 
-                    found = true;
+            case "ready": {
+                // host kicks off the match as soon as everyone in the room is ready
+                if (payload.allReady && state.isHost) sendControlMessage({channel: "start"});
+            }
+            break;
 
-                    player.xPos = retrieveFloatFromSignificantDigits(payload.playerXPos) * $canvas.width;
-                    player.yPos = retrieveFloatFromSignificantDigits(payload.playerYPos) * $canvas.height;
-                    player.xVel = retrieveFloatFromSignificantDigits(payload.playerXVel) * $canvas.width;
-                    player.yVel = retrieveFloatFromSignificantDigits(payload.playerYVel) * $canvas.height;
+            case "countdown": {
+                if (IS_DEV_MODE) console.log(`Received web socket message on 'countdown' channel for reason '${payload.reason}'`);
+                if (payload.reason === "resume") break; // the round goes on where it was paused
 
-                    if (payload.isHost) {
-                        state.puck.xPos = retrieveFloatFromSignificantDigits(payload.puckXPos) * $canvas.width;
-                        state.puck.yPos = retrieveFloatFromSignificantDigits(payload.puckYPos) * $canvas.height;
-                        state.puck.xVel = retrieveFloatFromSignificantDigits(payload.puckXVel) * $canvas.width;
-                        state.puck.yVel = retrieveFloatFromSignificantDigits(payload.puckYVel) * $canvas.height;
-                        setScore($leftScore, payload.leftScore);
-                        setScore($rightScore, payload.rightScore);
-                    }
+                const kickoffDelay = scheduleKickoff(payload);
+                if (payload.reason !== "matchStart") break;
+                showToast(`Match starting in ${Math.round(kickoffDelay / 1000)}s`);
+            }
+            break;
 
-                    break;
-                }
+            case "pause": {
+                openPauseMenu();
+                showToast(`${payload.userName} paused the match`);
+            }
+            break;
 
-                if (!found && payload.userName !== state.userName) { // ensure that this received remote state is not self's remote state sent back by server
-                    if (state.players.length === MAX_USERS_PER_ROOM) {
-                        console.error(`Server is trying to add a new player when room is full; current room count is ${MAX_USERS_PER_ROOM}`);
-                        return;
-                    }
-                    const newPlayer = new Player(payload.userName, payload.striker, payload.team, "remote");
-                    newPlayer.addToBoard();
-                    showToast(`Player ${payload.userName} joined`);
+            case "resume": {
+                if (state.isPaused) closePauseMenu($pauseMenu);
+                showToast(payload.userName === "" ? "Match resuming" : `${payload.userName} resumed the match`);
+            }
+            break;
+
+            case "matchOver": {
+                showToast(payload.winner === "draw" ? "Match drawn" : `${capitalizeFirstLetter(payload.winner)} team wins`);
+                sendControlMessage({channel: "ready", isReady: true});
+            }
+            break;
+
+            case "chat": {
+                showToast(`${payload.userName}: ${payload.text}`);
+            }
+            break;
+
+            case "error": {
+                if (IS_DEV_MODE) console.error(`Server rejected web socket message. Reason: ${payload.message}`);
+            }
+            break;
+
+            case "timeSync": {
+                recordTimeSync(payload);
+            }
+            break;
+
+            case "hit": {
+                if (IS_DEV_MODE) console.log(`Hit of state ${payload.seq} was ${payload.isAccepted ? "accepted" : `rejected: ${payload.message}`}`);
+            }
+            break;
+
+            case "snapshot": {
+                if (IS_DEV_MODE) console.log(`Received web socket message on 'snapshot' channel for tick ${payload.tick}`);
+
+                const remoteStates = resolveSnapshot(payload);
+                if (remoteStates === null) break;
+                sendControlMessage({channel: "ack", tick: payload.tick});
+
+                for (const remoteState of remoteStates) {
+                    applyRemoteState(remoteState);
                 }
+                if (remoteStates.length !== 0) applyServerPuck(remoteStates[0]);
             }
             break;
 
@@ -184,10 +266,191 @@ export function startOnlineGame(team, strikerIdx, playerType) {
 
     state.webSocketConn.onclose = () => {
         if (IS_DEV_MODE) console.log("Web socket connection closed");
+        if (state.isLeaving || state.sessionToken === null) {
+            exitGame();
+            return;
+        }
+
+        startReconnecting();
+    };
+}
+
+// the server holds the user's slot for a while after their connection is lost, so the game goes on if they come back in time
+function startReconnecting() {
+    state.reconnect.isReconnecting = true;
+    state.reconnect.attempt = 0;
+    state.reconnect.startTimestamp = window.performance.now();
+    resetConnectionTimeoutMetrics();
+    resetTimeSync();
+
+    showToast("Connection lost, reconnecting");
+    scheduleReconnect();
+}
+
+function scheduleReconnect() {
+    const delay = Math.min(RECONNECT_BASE_DELAY * 2 ** state.reconnect.attempt, RECONNECT_MAX_DELAY);
+    if (RECONNECT_GRACE_PERIOD < window.performance.now() - state.reconnect.startTimestamp + delay) {
         exitGame();
+        return;
+    }
+
+    state.reconnect.attempt++;
+    state.reconnect.timeoutId = setTimeout(reconnect, delay);
+}
+
+function reconnect() {
+    state.reconnect.timeoutId = -1;
+
+    const webSocketConn = new WebSocket(webSocketUrl());
+    webSocketConn.binaryType = "arraybuffer";
+    state.webSocketConn = webSocketConn;
+
+    webSocketConn.onopen = () => {
+        webSocketConn.send(JSON.stringify({
+            channel: "handshake",
+            userName: state.userName,
+            sessionToken: state.sessionToken,
+            protocol: CODEC_VERSION,
+            deltas: true,
+        }));
+        if (IS_DEV_MODE) console.log(`Sent web socket message on 'handshake' channel to resume session (attempt ${state.reconnect.attempt})`);
     };
 
-    startNewRound();
+    webSocketConn.onmessage = (event) => {
+        const payload = parseMessage(event.data);
+        if (payload.channel !== "handshake") {
+            if (IS_DEV_MODE) console.error("Received web socket message from invalid channel during handshake");
+            webSocketConn.close(webSocketErrors.wrongChannel.code, webSocketErrors.wrongChannel.reason);
+            return;
+        }
+
+        // the slot is gone once the session expired or the user was removed from the room while away
+        if (!payload.isSuccess || payload.roomName === undefined) {
+            if (IS_DEV_MODE) console.error(`Server refused to resume session. Reason: ${payload.message}`);
+            state.isLeaving = true;
+            webSocketConn.close(webSocketErrors.rejectedUsername.code, webSocketErrors.rejectedUsername.reason);
+            return;
+        }
+
+        resumeOnlineGame(payload);
+    };
+
+    webSocketConn.onerror = () => {
+        if (IS_DEV_MODE) console.error("Error while reconnecting web socket");
+        webSocketConn.close(webSocketErrors.clientError.code, webSocketErrors.clientError.reason);
+    };
+
+    webSocketConn.onclose = () => {
+        if (state.isLeaving) {
+            exitGame();
+            return;
+        }
+
+        scheduleReconnect();
+    };
+}
+
+function resumeOnlineGame(payload) {
+    state.reconnect.isReconnecting = false;
+    state.reconnect.attempt = 0;
+    state.protocol = payload.protocol ?? 0;
+    state.isHost = payload.isHost ?? false;
+    state.mainPlayer.team = payload.team;
+    state.mainPlayer.strikerIdx = payload.striker ?? 0;
+    state.snapshots.clear(); // deltas restart from a keyframe on the new connection
+
+    attachGameHandlers();
+    startConnectionTimeoutInterval();
+    startTimeSyncInterval();
+
+    showToast("Reconnected");
+}
+
+function sendControlMessage(payload) {
+    if (state.webSocketConn === null || state.webSocketConn.readyState !== WebSocket.OPEN) {
+        if (IS_DEV_MODE) console.error(`Cannot send web socket message on '${payload.channel}' channel as web socket connection is not open`);
+        return;
+    }
+
+    state.webSocketConn.send(serializeMessage(payload));
+}
+
+// returns the full states of a snapshot by applying a delta snapshot to the snapshot it is based on,
+// or null if that snapshot is unknown, in which case the server keeps sending deltas until the next keyframe
+function resolveSnapshot(payload) {
+    let remoteStates = payload.states;
+
+    if (payload.baseTick !== undefined) {
+        const baseStates = state.snapshots.get(payload.baseTick);
+        if (baseStates === undefined) return null;
+
+        const removed = new Set(payload.removed ?? []);
+        const changesByUserName = new Map(payload.states.map(changes => [changes.userName, changes]));
+        remoteStates = baseStates.filter(baseState => !removed.has(baseState.userName))
+            .map(baseState => ({...baseState, ...changesByUserName.get(baseState.userName)}));
+        for (const changes of payload.states) {
+            if (!baseStates.some(baseState => baseState.userName === changes.userName)) remoteStates.push(changes);
+        }
+    }
+
+    state.snapshots.set(payload.tick, remoteStates);
+    for (const tick of state.snapshots.keys()) {
+        if (tick <= payload.tick - SNAPSHOT_HISTORY_SIZE) state.snapshots.delete(tick);
+    }
+
+    return remoteStates;
+}
+
+// binary messages use the codec negotiated during the handshake, text messages are json
+function parseMessage(data) {
+    return typeof data === "string" ? JSON.parse(data) : decode(data);
+}
+
+function serializeMessage(payload) {
+    return state.protocol !== 0 ? encode(payload, state.protocol) : JSON.stringify(payload);
+}
+
+// the server simulates the puck and keeps the score, and stamps both into every state of a snapshot
+function applyServerPuck(payload) {
+    state.puck.xPos = retrieveFloatFromSignificantDigits(payload.puckXPos) * $canvas.width;
+    state.puck.yPos = retrieveFloatFromSignificantDigits(payload.puckYPos) * $canvas.height;
+    state.puck.xVel = retrieveFloatFromSignificantDigits(payload.puckXVel) * $canvas.width;
+    state.puck.yVel = retrieveFloatFromSignificantDigits(payload.puckYVel) * $canvas.height;
+
+    if (safeExtractScore($leftScore) !== payload.leftScore || safeExtractScore($rightScore) !== payload.rightScore) playSound("goal", false);
+    setScore($leftScore, payload.leftScore);
+    setScore($rightScore, payload.rightScore);
+}
+
+function applyRemoteState(payload) {
+    // a snapshot repeats a player's latest state until they send a new one, so only strictly older states are stale
+    const latestSeq = state.remoteSeqs.get(payload.userName);
+    if (latestSeq !== undefined && payload.seq < latestSeq) return;
+    state.remoteSeqs.set(payload.userName, payload.seq);
+
+    let found = false;
+    for (const player of state.players) {
+        if(player.name !== payload.userName || player === state.mainPlayer) continue;
+
+        found = true;
+
+        player.xPos = retrieveFloatFromSignificantDigits(payload.playerXPos) * $canvas.width;
+        player.yPos = retrieveFloatFromSignificantDigits(payload.playerYPos) * $canvas.height;
+        player.xVel = retrieveFloatFromSignificantDigits(payload.playerXVel) * $canvas.width;
+        player.yVel = retrieveFloatFromSignificantDigits(payload.playerYVel) * $canvas.height;
+
+        break;
+    }
+
+    if (!found && payload.userName !== state.userName) { // ensure that this received remote state is not self's remote state sent back by server
+        if (state.players.length === MAX_USERS_PER_ROOM) {
+            console.error(`Server is trying to add a new player when room is full; current room count is ${MAX_USERS_PER_ROOM}`);
+            return;
+        }
+        const newPlayer = new Player(payload.userName, payload.striker, payload.team, "remote");
+        newPlayer.addToBoard();
+        showToast(`Player ${payload.userName} joined`);
+    }
 }
 
 export async function createRoom(roomName, team, strikerIdx, playerType) {
@@ -328,7 +591,9 @@ export async function joinRoom(roomName, team, strikerIdx, playerType) {
 }
 
 export function sendRemoteState() {
-    if (state.webSocketConn === null) {
+    if (state.reconnect.isReconnecting) {
+        return;
+    } else if (state.webSocketConn === null) {
         if (IS_DEV_MODE) console.error("Cannot send remote state to server as web socket connection does not exist");
         exitGame();
         return;
@@ -340,6 +605,7 @@ export function sendRemoteState() {
 
     state.remoteState.userName = state.userName;
     state.remoteState.isHost = state.isHost;
+    state.remoteState.seq++;
     state.remoteState.team = state.mainPlayer.team;
     state.remoteState.striker = state.mainPlayer.strikerIdx;
     state.remoteState.playerXPos = getSignificantFloatDigits(state.mainPlayer.xPos / $canvas.width);
@@ -347,23 +613,7 @@ export function sendRemoteState() {
     state.remoteState.playerXVel = getSignificantFloatDigits(state.mainPlayer.xVel / $canvas.width);
     state.remoteState.playerYVel = getSignificantFloatDigits(state.mainPlayer.yVel / $canvas.height);
 
-    if (state.isHost) {
-        state.remoteState.puckXPos = getSignificantFloatDigits(state.puck.xPos / $canvas.width);
-        state.remoteState.puckYPos = getSignificantFloatDigits(state.puck.yPos / $canvas.height);
-        state.remoteState.puckXVel = getSignificantFloatDigits(state.puck.xVel / $canvas.width);
-        state.remoteState.puckYVel = getSignificantFloatDigits(state.puck.yVel / $canvas.height);
-        state.remoteState.leftScore = safeExtractScore($leftScore);
-        state.remoteState.rightScore = safeExtractScore($rightScore);
-    } else {
-        state.remoteState.puckXPos = undefined;
-        state.remoteState.puckYPos = undefined;
-        state.remoteState.puckXVel = undefined;
-        state.remoteState.puckYVel = undefined;
-        state.remoteState.leftScore = undefined;
-        state.remoteState.rightScore = undefined;
-    }
-
-    state.webSocketConn.send(JSON.stringify(state.remoteState));
+    state.webSocketConn.send(serializeMessage(state.remoteState));
 }
 
 export function startConnectionTimeoutInterval() {
@@ -386,10 +636,97 @@ export function resetConnectionTimeoutMetrics() {
     state.connTimeoutMetrics.prevMsgTimestamp = Infinity;
 }
 
+export function startTimeSyncInterval() {
+    resetTimeSync();
+    for (let i = 0; i < TIME_SYNC_BURST_SIZE; i++) {
+        state.clock.timeoutIds.push(setTimeout(sendTimeSyncRequest, i * TIME_SYNC_BURST_INTERVAL));
+    }
+    state.clock.intervalId = setInterval(sendTimeSyncRequest, TIME_SYNC_INTERVAL);
+}
+
+function sendTimeSyncRequest() {
+    if (!state.isOnlineGame) return;
+    sendControlMessage({channel: "timeSync", clientTime: window.performance.now()});
+}
+
+// assumes the server read its clock halfway through the round trip, so the sample with the lowest round trip is the most accurate
+function recordTimeSync(payload) {
+    const now = window.performance.now();
+    const rtt = now - payload.clientTime;
+    if (rtt < 0) return;
+
+    state.clock.samples.push({rtt, offset: payload.serverTime + rtt / 2 - now});
+    if (TIME_SYNC_SAMPLE_COUNT < state.clock.samples.length) state.clock.samples.shift();
+
+    const bestSample = state.clock.samples.reduce((best, sample) => sample.rtt < best.rtt ? sample : best);
+    state.clock.offset = bestSample.offset;
+    state.clock.rtt = bestSample.rtt;
+    if (IS_DEV_MODE) console.log(`Clock offset is ${state.clock.offset.toFixed(1)} ms, round trip took ${rtt.toFixed(1)} ms, server measured ${payload.rtt ?? "-"} ms`);
+}
+
+// starts the new round locally at the moment the server releases the puck and returns how long that is from now;
+// until the clock is synced, the countdown is assumed to have started when its message arrived
+function scheduleKickoff(payload) {
+    const now = window.performance.now();
+    const localKickoffTime = state.clock.samples.length === 0 ? now + payload.kickoffTime - payload.serverTime : toLocalTime(payload.kickoffTime);
+    const kickoffDelay = Math.max(0, localKickoffTime - now);
+
+    clearTimeout(state.kickoffTimeoutId);
+    state.kickoffTimeoutId = setTimeout(startNewRound, kickoffDelay);
+    return kickoffDelay;
+}
+
+// asks the server to pause or resume the match for everyone in the room
+export function sendPauseRequest(isPaused) {
+    sendControlMessage({channel: isPaused ? "pause" : "resume"});
+}
+
+// converts unix time in milliseconds sent by the server to the timeline of window.performance.now()
+export function toLocalTime(serverTime) {
+    return serverTime - state.clock.offset;
+}
+
+// asks the server to replay a hit of the main player's striker that it may have missed because of latency;
+// the puck on screen left the server about half a round trip ago, and the striker is the one of the latest state sent
+export function reportHit() {
+    if (state.clock.samples.length === 0 || state.remoteState.seq === 0) return;
+
+    const perceivedServerTime = window.performance.now() + state.clock.offset - state.clock.rtt / 2;
+    sendControlMessage({channel: "hit", seq: state.remoteState.seq, serverTime: Math.round(perceivedServerTime)});
+}
+
+export function resetTimeSync() {
+    for (const timeoutId of state.clock.timeoutIds) clearTimeout(timeoutId);
+    clearInterval(state.clock.intervalId);
+    state.clock.timeoutIds = [];
+    state.clock.intervalId = -1;
+    state.clock.samples = [];
+    state.clock.offset = 0;
+    state.clock.rtt = 0;
+}
+
 export function resetPostDisconnect() {
     resetConnectionTimeoutMetrics();
+    resetTimeSync();
+    clearTimeout(state.reconnect.timeoutId);
+    clearTimeout(state.kickoffTimeoutId);
+    state.kickoffTimeoutId = -1;
+    if (state.reconnect.isReconnecting && state.webSocketConn !== null) {
+        // a pending reconnection attempt must not resume the session after the user left
+        state.webSocketConn.onclose = null;
+        state.webSocketConn.close();
+    }
+    state.reconnect.isReconnecting = false;
+    state.reconnect.attempt = 0;
+    state.reconnect.timeoutId = -1;
     state.webSocketConn = null;
     state.userName = null;
+    state.sessionToken = null;
+    state.isLeaving = false;
+    state.protocol = 0;
+    state.snapshots.clear();
+    state.remoteSeqs.clear();
+    state.remoteState.seq = 0;
     state.mainPlayer.name = "You";
     state.mainPlayer.team = "left";
     state.mainPlayer.strikerIdx = 0;
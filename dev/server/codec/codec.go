@@ -0,0 +1,352 @@
+// Package codec converts web socket messages between json and a compact binary encoding.
+//
+// A binary message is a version byte followed by one value, which must be an object. Values start with a tag:
+//
+//	tagNull, tagFalse, tagTrue
+//	tagInt          zigzag uvarint
+//	tagFloat        8 byte big endian IEEE 754 double
+//	tagString       uvarint byte length, utf-8 bytes
+//	tagKnownString  uvarint index into the version's dictionary
+//	tagArray        uvarint count, that many values
+//	tagObject       uvarint count, that many keys each followed by a value
+//
+// Keys are a uvarint which is 0 for a literal string (uvarint byte length, utf-8 bytes) and otherwise
+// 1 plus an index into the version's dictionary. Older versions stay decodable, so clients that were
+// loaded before a deploy keep working. Since positions and velocities already travel as small ints,
+// a state shrinks from about 250 bytes of json to about 50.
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Version is the latest binary encoding; clients ask for the one they know during the handshake, and the server
+// answers with the older of the two
+const Version = 2
+
+const maxDepth = 16 // max nesting of arrays and objects
+
+const (
+	tagNull byte = iota
+	tagFalse
+	tagTrue
+	tagInt
+	tagFloat
+	tagString
+	tagKnownString
+	tagArray
+	tagObject
+)
+
+var (
+	ErrVersion   = errors.New("codec: unsupported version")
+	ErrMalformed = errors.New("codec: malformed message")
+	ErrNotObject = errors.New("codec: message must be an object")
+	ErrTooDeep   = errors.New("codec: message is nested too deeply")
+)
+
+// Encode converts a json object to the binary encoding of version; object keys are written in sorted order
+func Encode(version int, jsonData []byte) ([]byte, error) {
+	if version < 1 || Version < version {
+		return nil, ErrVersion
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.UseNumber()
+
+	var value any
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	} else if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("codec: trailing data after json object")
+	} else if _, ok := value.(map[string]any); !ok {
+		return nil, ErrNotObject
+	}
+
+	enc := encoder{dictionarySize: dictionarySizes[version-1]}
+	return enc.appendValue(append(make([]byte, 0, len(jsonData)/2), byte(version)), value, 0)
+}
+
+type encoder struct {
+	dictionarySize int
+}
+
+// returns the dictionary index of value, if the encoder's version knows it
+func (enc *encoder) knownIndex(value string) (int, bool) {
+	idx, ok := dictionaryIndices[value]
+	return idx, ok && idx < enc.dictionarySize
+}
+
+func (enc *encoder) appendValue(buf []byte, value any, depth int) ([]byte, error) {
+	if maxDepth < depth {
+		return nil, ErrTooDeep
+	}
+
+	switch value := value.(type) {
+	case nil:
+		return append(buf, tagNull), nil
+	case bool:
+		if value {
+			return append(buf, tagTrue), nil
+		}
+		return append(buf, tagFalse), nil
+	case json.Number:
+		if integer, err := value.Int64(); err == nil {
+			buf = append(buf, tagInt)
+			return binary.AppendUvarint(buf, uint64(integer<<1)^uint64(integer>>63)), nil
+		}
+
+		float, err := value.Float64()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, tagFloat)
+		return binary.BigEndian.AppendUint64(buf, math.Float64bits(float)), nil
+	case string:
+		if idx, ok := enc.knownIndex(value); ok {
+			buf = append(buf, tagKnownString)
+			return binary.AppendUvarint(buf, uint64(idx)), nil
+		}
+		buf = append(buf, tagString)
+		return appendString(buf, value), nil
+	case []any:
+		buf = append(buf, tagArray)
+		buf = binary.AppendUvarint(buf, uint64(len(value)))
+		for _, elem := range value {
+			var err error
+			buf, err = enc.appendValue(buf, elem, depth+1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]any:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+
+		buf = append(buf, tagObject)
+		buf = binary.AppendUvarint(buf, uint64(len(keys)))
+		for _, key := range keys {
+			if idx, ok := enc.knownIndex(key); ok {
+				buf = binary.AppendUvarint(buf, uint64(idx)+1)
+			} else {
+				buf = binary.AppendUvarint(buf, 0)
+				buf = appendString(buf, key)
+			}
+
+			var err error
+			buf, err = enc.appendValue(buf, value[key], depth+1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+
+	return nil, errors.New("codec: unexpected json value")
+}
+
+func appendString(buf []byte, value string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+// Decode converts a binary message of any version back to a json object
+func Decode(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, ErrMalformed
+	} else if data[0] < 1 || Version < data[0] {
+		return nil, ErrVersion
+	} else if len(data) < 2 || data[1] != tagObject {
+		return nil, ErrNotObject
+	}
+
+	dec := decoder{data: data, pos: 1, dictionarySize: dictionarySizes[data[0]-1]}
+	jsonData, err := dec.appendValue(make([]byte, 0, 4*len(data)), 0)
+	if err != nil {
+		return nil, err
+	} else if dec.pos != len(data) {
+		return nil, ErrMalformed
+	}
+
+	return jsonData, nil
+}
+
+type decoder struct {
+	data           []byte
+	pos            int
+	dictionarySize int
+}
+
+func (dec *decoder) appendValue(buf []byte, depth int) ([]byte, error) {
+	if maxDepth < depth {
+		return nil, ErrTooDeep
+	} else if len(dec.data) <= dec.pos {
+		return nil, ErrMalformed
+	}
+
+	tag := dec.data[dec.pos]
+	dec.pos++
+
+	switch tag {
+	case tagNull:
+		return append(buf, "null"...), nil
+	case tagFalse:
+		return append(buf, "false"...), nil
+	case tagTrue:
+		return append(buf, "true"...), nil
+	case tagInt:
+		zigzag, err := dec.readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(buf, int64(zigzag>>1)^-int64(zigzag&1), 10), nil
+	case tagFloat:
+		if len(dec.data)-dec.pos < 8 {
+			return nil, ErrMalformed
+		}
+		float := math.Float64frombits(binary.BigEndian.Uint64(dec.data[dec.pos:]))
+		dec.pos += 8
+		if math.IsNaN(float) || math.IsInf(float, 0) {
+			return nil, ErrMalformed
+		}
+		return strconv.AppendFloat(buf, float, 'g', -1, 64), nil
+	case tagString:
+		value, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		return appendJSONString(buf, value), nil
+	case tagKnownString:
+		value, err := dec.readKnownString()
+		if err != nil {
+			return nil, err
+		}
+		return appendJSONString(buf, value), nil
+	case tagArray:
+		count, err := dec.readCount()
+		if err != nil {
+			return nil, err
+		}
+
+		buf = append(buf, '[')
+		for i := 0; i < count; i++ {
+			if 0 < i {
+				buf = append(buf, ',')
+			}
+			buf, err = dec.appendValue(buf, depth+1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, ']'), nil
+	case tagObject:
+		count, err := dec.readCount()
+		if err != nil {
+			return nil, err
+		}
+
+		buf = append(buf, '{')
+		for i := 0; i < count; i++ {
+			if 0 < i {
+				buf = append(buf, ',')
+			}
+
+			keyRef, err := dec.readUvarint()
+			if err != nil {
+				return nil, err
+			}
+
+			var key string
+			if keyRef == 0 {
+				key, err = dec.readString()
+			} else {
+				key, err = dec.knownString(keyRef - 1)
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			buf = appendJSONString(buf, key)
+			buf = append(buf, ':')
+			buf, err = dec.appendValue(buf, depth+1)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return append(buf, '}'), nil
+	}
+
+	return nil, ErrMalformed
+}
+
+func (dec *decoder) readUvarint() (uint64, error) {
+	value, n := binary.Uvarint(dec.data[dec.pos:])
+	if n <= 0 {
+		return 0, ErrMalformed
+	}
+
+	dec.pos += n
+	return value, nil
+}
+
+// every element takes at least a byte, so counts beyond the remaining bytes are malformed
+func (dec *decoder) readCount() (int, error) {
+	count, err := dec.readUvarint()
+	if err != nil {
+		return 0, err
+	} else if uint64(len(dec.data)-dec.pos) < count {
+		return 0, ErrMalformed
+	}
+
+	return int(count), nil
+}
+
+func (dec *decoder) readString() (string, error) {
+	length, err := dec.readCount()
+	if err != nil {
+		return "", err
+	}
+
+	value := dec.data[dec.pos : dec.pos+length]
+	dec.pos += length
+	if !utf8.Valid(value) {
+		return "", ErrMalformed
+	}
+
+	return string(value), nil
+}
+
+func (dec *decoder) readKnownString() (string, error) {
+	idx, err := dec.readUvarint()
+	if err != nil {
+		return "", err
+	}
+
+	return dec.knownString(idx)
+}
+
+func (dec *decoder) knownString(idx uint64) (string, error) {
+	if uint64(dec.dictionarySize) <= idx {
+		return "", ErrMalformed
+	}
+
+	return dictionary[idx], nil
+}
+
+func appendJSONString(buf []byte, value string) []byte {
+	quoted, _ := json.Marshal(value) // can't fail for strings
+	return append(buf, quoted...)
+}
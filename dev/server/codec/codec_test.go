@@ -0,0 +1,237 @@
+package codec
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+var samples = []string{
+	`{"channel":"state","userName":"jomin","isHost":true,"team":"left","striker":0,"playerXPos":312,"playerYPos":498,"playerXVel":-7,"playerYVel":3,"puckXPos":500,"puckYPos":500,"puckXVel":0,"puckYVel":0,"leftScore":2,"rightScore":1}`,
+	`{"channel":"snapshot","tick":18446744,"phase":"playing","states":[{"channel":"state","userName":"bot-1","isHost":false,"team":"right","striker":1,"playerXPos":900,"playerYPos":500,"playerXVel":0,"playerYVel":0,"puckXPos":0,"puckYPos":0,"puckXVel":0,"puckYVel":0,"leftScore":0,"rightScore":0}]}`,
+	`{"channel":"chat","userName":"suhan","text":"gg ⚽ \"wp\"\n","quickChat":"","isSpectator":false,"serverTime":1760000000000}`,
+	`{"channel":"handshake","userName":"minji","protocol":1}`,
+	`{"channel":"error","message":"room is full"}`,
+	`{"channel":"matchFound","ratings":{"jomin":1516,"suhan":1484},"players":["jomin","suhan"],"ratio":0.5,"big":-9007199254740993,"none":null,"nested":[[[]],{}]}`,
+}
+
+// compares json documents by value, ignoring key order and number formatting
+func assertSameJSON(t *testing.T, want []byte, got []byte) {
+	t.Helper()
+
+	var wantValue, gotValue any
+	if err := json.Unmarshal(want, &wantValue); err != nil {
+		t.Fatalf("invalid json %q: %v", want, err)
+	}
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("invalid json %q: %v", got, err)
+	}
+	if !reflect.DeepEqual(wantValue, gotValue) {
+		t.Fatalf("json differs\nwant %s\ngot  %s", want, got)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, sample := range samples {
+		data, err := Encode(Version, []byte(sample))
+		if err != nil {
+			t.Fatalf("Encode(%s): %v", sample, err)
+		}
+
+		jsonData, err := Decode(data)
+		if err != nil {
+			t.Fatalf("Decode of %s: %v", sample, err)
+		}
+		assertSameJSON(t, []byte(sample), jsonData)
+	}
+}
+
+func TestStateIsCompact(t *testing.T) {
+	data, err := Encode(Version, []byte(samples[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if 4*len(data) > len(samples[0]) {
+		t.Fatalf("state takes %d bytes, want at most a quarter of its %d bytes of json", len(data), len(samples[0]))
+	}
+}
+
+func TestEncodeRejectsNonObjects(t *testing.T) {
+	for _, input := range []string{`[]`, `"state"`, `1`, `null`, `{} {}`, `{`} {
+		_, err := Encode(Version, []byte(input))
+		if err == nil {
+			t.Errorf("Encode(%s) succeeded, want error", input)
+		}
+	}
+}
+
+func TestDecodeRejectsMalformed(t *testing.T) {
+	valid, err := Encode(Version, []byte(samples[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrMalformed},
+		{"unknown version", append([]byte{Version + 1}, valid[1:]...), ErrVersion},
+		{"version zero", append([]byte{0}, valid[1:]...), ErrVersion},
+		{"entry of a later version", []byte{1, tagObject, 1, byte(dictionarySizes[0] + 1), tagNull}, ErrMalformed},
+		{"array", []byte{Version, tagArray, 0}, ErrNotObject},
+		{"truncated", valid[:len(valid)-1], ErrMalformed},
+		{"trailing byte", append(valid[:len(valid):len(valid)], tagNull), ErrMalformed},
+		{"count beyond data", []byte{Version, tagObject, 100}, ErrMalformed},
+		{"unknown tag", []byte{Version, tagObject, 1, 1, 0xff}, ErrMalformed},
+		{"unknown key", []byte{Version, tagObject, 1, 0xff, 0x01, tagNull}, ErrMalformed},
+		{"invalid utf-8", []byte{Version, tagObject, 1, 0, 1, 0xff, tagNull}, ErrMalformed},
+		{"not a number", []byte{Version, tagObject, 1, 1, tagFloat, 0x7f, 0xf8, 0, 0, 0, 0, 0, 1}, ErrMalformed},
+	}
+
+	for _, test := range tests {
+		_, err := Decode(test.data)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: got error %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestDecodeRejectsDeepNesting(t *testing.T) {
+	data := []byte{Version, tagObject, 1, 1}
+	for i := 0; i < 2*maxDepth; i++ {
+		data = append(data, tagArray, 1)
+	}
+	data = append(data, tagNull)
+
+	_, err := Decode(data)
+	if !errors.Is(err, ErrTooDeep) {
+		t.Fatalf("got error %v, want %v", err, ErrTooDeep)
+	}
+}
+
+func TestDictionaryHasNoDuplicates(t *testing.T) {
+	if len(dictionaryIndices) != len(dictionary) {
+		t.Fatalf("dictionary has %d entries but only %d are unique", len(dictionary), len(dictionaryIndices))
+	}
+}
+
+// a released version's entries must never change, clients loaded before a deploy still decode with them
+func TestDictionaryVersionsAreFrozen(t *testing.T) {
+	hashes := [Version]string{
+		"97de388266760e3ae49278cf7bf32e2fa5dbe3e1a34da1ff7d5998d14fcc67c9",
+		"309adb72579a93d7a8530c17c6a808f85fecc33e97cce0907a4a0686c0b4ab0b",
+	}
+
+	for idx, size := range dictionarySizes {
+		hash := sha256.Sum256([]byte(strings.Join(dictionary[:size], "\n")))
+		if hex.EncodeToString(hash[:]) != hashes[idx] {
+			t.Errorf("dictionary of version %d changed; append new entries under a new version instead", idx+1)
+		}
+	}
+	if dictionarySizes[Version-1] != len(dictionary) {
+		t.Errorf("latest version uses %d of %d dictionary entries", dictionarySizes[Version-1], len(dictionary))
+	}
+}
+
+func TestOlderVersionsRoundTrip(t *testing.T) {
+	sample := `{"channel":"hit","seq":7,"isAccepted":true,"tick":3}`
+	for version := 1; version <= Version; version++ {
+		data, err := Encode(version, []byte(sample))
+		if err != nil {
+			t.Fatalf("Encode(%d, %s): %v", version, sample, err)
+		} else if data[0] != byte(version) {
+			t.Fatalf("Encode(%d, %s) wrote version %d", version, sample, data[0])
+		}
+
+		jsonData, err := Decode(data)
+		if err != nil {
+			t.Fatalf("Decode of version %d: %v", version, err)
+		}
+		assertSameJSON(t, []byte(sample), jsonData)
+	}
+
+	if _, err := Encode(Version+1, []byte(sample)); !errors.Is(err, ErrVersion) {
+		t.Fatalf("Encode of unknown version: got error %v, want %v", err, ErrVersion)
+	}
+}
+
+func TestDictionaryMatchesClient(t *testing.T) {
+	source, err := os.ReadFile("../../client/src/scripts/codec.js")
+	if err != nil {
+		t.Skip("client source not available:", err)
+	}
+
+	start := strings.Index(string(source), "const dictionary = [")
+	if start < 0 {
+		t.Fatal("could not find dictionary in codec.js")
+	}
+	end := strings.Index(string(source[start:]), "];")
+
+	var clientDictionary []string
+	for _, match := range regexp.MustCompile(`"([^"]*)"`).FindAllStringSubmatch(string(source[start:start+end]), -1) {
+		clientDictionary = append(clientDictionary, match[1])
+	}
+
+	if !slices.Equal(dictionary, clientDictionary) {
+		t.Fatalf("dictionary differs from codec.js\ngo %v\njs %v", dictionary, clientDictionary)
+	}
+}
+
+func FuzzEncode(f *testing.F) {
+	for _, sample := range samples {
+		f.Add([]byte(sample))
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		data, err := Encode(Version, input)
+		if err != nil {
+			return
+		}
+
+		jsonData, err := Decode(data)
+		if err != nil {
+			t.Fatalf("Decode of encoded %q: %v", input, err)
+		}
+		assertSameJSON(t, input, jsonData)
+	})
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, sample := range samples {
+		data, err := Encode(Version, []byte(sample))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Add([]byte{Version, tagObject, 0})
+	f.Add([]byte{Version, tagObject, 1, 0, 0, tagFloat, 0, 0, 0, 0, 0, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		jsonData, err := Decode(data)
+		if err != nil {
+			return
+		}
+
+		// anything that decodes must be a json object that survives another round trip
+		reencoded, err := Encode(Version, jsonData)
+		if err != nil {
+			t.Fatalf("Encode of decoded %s: %v", jsonData, err)
+		}
+		jsonDataAgain, err := Decode(reencoded)
+		if err != nil {
+			t.Fatalf("Decode of re-encoded %s: %v", jsonData, err)
+		}
+		assertSameJSON(t, jsonData, jsonDataAgain)
+	})
+}
@@ -0,0 +1,41 @@
+package codec
+
+// strings that are sent as a single index instead of in full, both as object keys and as string values;
+// each version uses the first dictionarySizes[version-1] entries, so the entries of a released version never change
+// and new strings are appended under a new Version; the client's codec.js must hold the same list
+var dictionary = []string{
+	// version 1 fields
+	"channel", "userName", "team", "striker", "isHost", "isReady", "allReady", "isSuccess", "message",
+	"playerXPos", "playerYPos", "playerXVel", "playerYVel", "puckXPos", "puckYPos", "puckXVel", "puckYVel",
+	"leftScore", "rightScore", "tick", "phase", "states", "serverTime", "kickoffTime", "reason",
+	"text", "quickChat", "isSpectator", "messages", "difficulty", "mode", "isLocked", "isBanned",
+	"fromUserName", "toUserName", "userNames", "pausesLeft", "autoResumeTime", "gracePeriod", "timeout",
+	"roomName", "ratings", "winner", "duration", "players", "replayId",
+	// version 1 channels
+	"handshake", "state", "snapshot", "ready", "start", "countdown", "pause", "resume", "matchOver",
+	"chat", "chatHistory", "error", "memberLeft", "reassignHost", "hostTransferred", "kicked",
+	"memberKicked", "memberBanned", "roomLocked", "roomClosed", "switch", "memberSwitched",
+	"memberDisconnected", "memberReconnected", "addBot", "removeBot", "botAdded", "queue", "cancelQueue",
+	"queued", "queueCancelled", "queueTimeout", "matchFound", "kick", "lock", "transferHost",
+	// version 1 values
+	"left", "right", "lobby", "playing", "paused", "matchStart", "goal", "stuckPuck",
+	"easy", "medium", "hard", "1v1", "2v2",
+	// version 2 delta snapshots
+	"ack", "baseTick", "removed",
+	// version 2 time sync
+	"timeSync", "clientTime", "rtt", "jitter",
+	// version 2 state sequencing
+	"seq",
+	// version 2 lag compensation
+	"hit", "isAccepted",
+}
+
+var dictionarySizes = [Version]int{95, 105}
+
+var dictionaryIndices = func() map[string]int {
+	indices := make(map[string]int, len(dictionary))
+	for idx, entry := range dictionary {
+		indices[entry] = idx
+	}
+	return indices
+}()
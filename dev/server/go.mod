@@ -2,4 +2,9 @@ module goal
 
 go 1.23.1
 
-require github.com/gorilla/websocket v1.5.3 // direct
\ No newline at end of file
+require github.com/gorilla/websocket v1.5.3 // direct
+
+require (
+	golang.org/x/crypto v0.41.0
+	golang.org/x/sys v0.35.0 // indirect
+)
@@ -0,0 +1,366 @@
+// Command loadtest launches simulated clients against a running server. Clients are grouped into rooms,
+// do the handshake, create or join their room, ready up and stream state at a fixed rate, after which
+// latency percentiles, dropped connections and server errors are reported.
+//
+//	go run ./loadtest -addr 127.0.0.1:8080 -clients 32 -players 4 -fps 60 -duration 30s -protocol 1 -deltas
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"goal/codec"
+
+	"github.com/gorilla/websocket"
+)
+
+const snapshotHistorySize = 64 // same as the server's deltaHistorySize
+
+type config struct {
+	addr           string
+	clients        int
+	playersPerRoom int
+	fps            int
+	duration       time.Duration
+	rampUp         time.Duration
+	prefix         string
+	protocol       int
+	deltas         bool
+}
+
+// lets the players of a room wait until its host has created it
+type roomSync struct {
+	created chan struct{}
+	ok      bool
+}
+
+var (
+	handshakeLatencies  latencies
+	createRoomLatencies latencies
+	joinRoomLatencies   latencies
+	pingLatencies       latencies
+	events              counter
+	httpClient          = &http.Client{Timeout: 10 * time.Second}
+)
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.addr, "addr", "127.0.0.1:8080", "host:port of the server")
+	flag.IntVar(&cfg.clients, "clients", 8, "number of simulated clients")
+	flag.IntVar(&cfg.playersPerRoom, "players", 2, "clients per room, between 1 and 4")
+	flag.IntVar(&cfg.fps, "fps", 60, "state messages sent per second by each client")
+	flag.DurationVar(&cfg.duration, "duration", 30*time.Second, "how long each client streams state")
+	flag.DurationVar(&cfg.rampUp, "ramp", 0, "time over which client connections are spread out")
+	flag.StringVar(&cfg.prefix, "prefix", "lt", "prefix of generated user and room names")
+	flag.IntVar(&cfg.protocol, "protocol", 0, "binary codec version to ask for during the handshake, 0 for json")
+	flag.BoolVar(&cfg.deltas, "deltas", false, "ask for delta snapshots and acknowledge every snapshot")
+	flag.Parse()
+
+	if cfg.clients < 1 || cfg.playersPerRoom < 1 || 4 < cfg.playersPerRoom || cfg.fps < 1 {
+		fmt.Fprintln(os.Stderr, "clients and fps must be positive, players must be between 1 and 4")
+		os.Exit(2)
+	}
+
+	roomCount := (cfg.clients + cfg.playersPerRoom - 1) / cfg.playersPerRoom
+	fmt.Printf("launching %d clients in %d rooms against %s for %v at %d fps\n", cfg.clients, roomCount, cfg.addr, cfg.duration, cfg.fps)
+
+	roomSyncs := make([]*roomSync, roomCount)
+	for i := range roomSyncs {
+		roomSyncs[i] = &roomSync{created: make(chan struct{})}
+	}
+
+	startTimestamp := time.Now()
+	var waitGroup sync.WaitGroup
+	for i := 0; i < cfg.clients; i++ {
+		waitGroup.Add(1)
+		delay := time.Duration(0)
+		if 1 < cfg.clients {
+			delay = cfg.rampUp * time.Duration(i) / time.Duration(cfg.clients-1)
+		}
+
+		go func(clientIdx int) {
+			defer waitGroup.Done()
+			time.Sleep(delay)
+			runClient(cfg, clientIdx, roomSyncs[clientIdx/cfg.playersPerRoom])
+		}(i)
+	}
+	waitGroup.Wait()
+
+	fmt.Printf("\nfinished in %v\n\nlatencies\n", time.Since(startTimestamp).Round(time.Millisecond))
+	fmt.Println(handshakeLatencies.summary("handshake"))
+	fmt.Println(createRoomLatencies.summary("createRoom"))
+	fmt.Println(joinRoomLatencies.summary("joinRoom"))
+	fmt.Println(pingLatencies.summary("ping"))
+
+	fmt.Println("\nevents")
+	for _, line := range events.lines() {
+		fmt.Println(line)
+	}
+
+	if 0 < events.get("dropped")+events.get("connectFailed") {
+		os.Exit(1)
+	}
+}
+
+func runClient(cfg config, clientIdx int, roomSyncPtr *roomSync) {
+	userName := fmt.Sprintf("%s%d", cfg.prefix, clientIdx)
+	roomName := fmt.Sprintf("%sr%d", cfg.prefix, clientIdx/cfg.playersPerRoom)
+	playerIdx := clientIdx % cfg.playersPerRoom
+	isHost := playerIdx == 0
+	team := "left"
+	if playerIdx%2 == 1 {
+		team = "right"
+	}
+
+	// connect and handshake
+	handshakeStartTimestamp := time.Now()
+	conn, protocol, err := connect(cfg.addr, userName, cfg.protocol, cfg.deltas)
+	if err != nil {
+		log.Printf("[ERROR] client %s could not connect. Reason: %v\n", userName, err)
+		events.inc("connectFailed")
+		if isHost {
+			close(roomSyncPtr.created)
+		}
+		return
+	}
+	defer conn.Close()
+	handshakeLatencies.add(time.Since(handshakeStartTimestamp))
+	events.inc("connected")
+
+	// create or join room
+	roomPayload := map[string]any{"roomName": roomName, "userName": userName, "team": team, "striker": playerIdx}
+	if isHost {
+		roomSyncPtr.ok = post(cfg.addr, "/room", roomPayload, &createRoomLatencies)
+		close(roomSyncPtr.created)
+		if !roomSyncPtr.ok {
+			return
+		}
+	} else {
+		<-roomSyncPtr.created
+		if !roomSyncPtr.ok || !post(cfg.addr, "/join", roomPayload, &joinRoomLatencies) {
+			return
+		}
+	}
+
+	// gorilla/websocket supports one concurrent writer, apart from control frames
+	var writeMu sync.Mutex
+	writeJSON := func(payload any) error {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+
+		messageType := websocket.TextMessage
+		if protocol != 0 {
+			data, err = codec.Encode(protocol, data)
+			if err != nil {
+				return err
+			}
+			messageType = websocket.BinaryMessage
+		}
+		events.add("bytesSent", len(data))
+
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteMessage(messageType, data)
+	}
+
+	conn.SetPongHandler(func(appData string) error {
+		sentNanos, err := strconv.ParseInt(appData, 10, 64)
+		if err == nil {
+			pingLatencies.add(time.Since(time.Unix(0, sentNanos)))
+		}
+		return nil
+	})
+
+	// read until the connection closes; the host starts the match once everyone is ready
+	readErrChannel := make(chan error, 1)
+	receivedTicks := make(map[uint64]bool)
+	go func() {
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				readErrChannel <- err
+				return
+			}
+
+			events.add("bytesReceived", len(data))
+			if messageType == websocket.BinaryMessage {
+				data, err = codec.Decode(data)
+				if err != nil {
+					events.inc("badMessages")
+					continue
+				}
+			}
+
+			var header struct {
+				Channel  string  `json:"channel"`
+				AllReady bool    `json:"allReady"`
+				Tick     uint64  `json:"tick"`
+				BaseTick *uint64 `json:"baseTick"`
+			}
+			if json.Unmarshal(data, &header) != nil {
+				events.inc("badMessages")
+				continue
+			}
+
+			switch header.Channel {
+			case "snapshot":
+				events.inc("snapshots")
+				if !cfg.deltas {
+					break
+				}
+
+				// only the ticks matter here, the states themselves aren't applied
+				if header.BaseTick == nil {
+					events.inc("keyframes")
+				} else if receivedTicks[*header.BaseTick] {
+					events.inc("deltas")
+				} else {
+					events.inc("unknownBase")
+					break
+				}
+				receivedTicks[header.Tick] = true
+				delete(receivedTicks, header.Tick-snapshotHistorySize)
+				writeJSON(map[string]any{"channel": "ack", "tick": header.Tick})
+			case "error":
+				events.inc("serverErrors")
+			case "ready":
+				if isHost && header.AllReady {
+					writeJSON(map[string]any{"channel": "start"})
+				}
+			}
+		}
+	}()
+
+	err = writeJSON(map[string]any{"channel": "ready", "isReady": true})
+	if err != nil {
+		events.inc("dropped")
+		return
+	}
+
+	// stream state, moving the striker along a circle so that its position keeps changing
+	stateTicker := time.NewTicker(time.Second / time.Duration(cfg.fps))
+	defer stateTicker.Stop()
+	pingTicker := time.NewTicker(time.Second)
+	defer pingTicker.Stop()
+	endTimer := time.NewTimer(cfg.duration)
+	defer endTimer.Stop()
+
+	xCenter := 250
+	if team == "right" {
+		xCenter = 750
+	}
+
+	seq := 0 // states sent so far, pings don't count
+	for {
+		select {
+		case <-endTimer.C:
+			writeMu.Lock()
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "load test over"), time.Now().Add(time.Second))
+			writeMu.Unlock()
+			return
+		case err := <-readErrChannel:
+			log.Printf("[ERROR] client %s was disconnected. Reason: %v\n", userName, err)
+			events.inc("dropped")
+			return
+		case <-pingTicker.C:
+			err := conn.WriteControl(websocket.PingMessage, []byte(strconv.FormatInt(time.Now().UnixNano(), 10)), time.Now().Add(time.Second))
+			if err != nil {
+				events.inc("pingFailed")
+			}
+		case <-stateTicker.C:
+			seq++
+			angle := 2 * math.Pi * float64(seq) / float64(cfg.fps)
+			err := writeJSON(map[string]any{
+				"channel":    "state",
+				"userName":   userName,
+				"team":       team,
+				"striker":    playerIdx,
+				"playerXPos": xCenter + int(100*math.Cos(angle)),
+				"playerYPos": 500 + int(200*math.Sin(angle)),
+				"seq":        seq,
+			})
+			if err != nil {
+				log.Printf("[ERROR] client %s could not send state. Reason: %v\n", userName, err)
+				events.inc("dropped")
+				return
+			}
+			events.inc("statesSent")
+		}
+	}
+}
+
+// returns the connection along with the negotiated codec version, 0 meaning json
+func connect(addr string, userName string, protocol int, deltas bool) (*websocket.Conn, int, error) {
+	header := http.Header{}
+	header.Set("Origin", "http://"+addr)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/user", header)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = conn.WriteJSON(map[string]any{"channel": "handshake", "userName": userName, "protocol": protocol, "deltas": deltas})
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	}
+
+	var res struct {
+		IsSuccess bool   `json:"isSuccess"`
+		Message   string `json:"message"`
+		Protocol  int    `json:"protocol"`
+	}
+	err = conn.ReadJSON(&res)
+	if err != nil {
+		conn.Close()
+		return nil, 0, err
+	} else if !res.IsSuccess {
+		conn.Close()
+		return nil, 0, fmt.Errorf("handshake rejected: %s", res.Message)
+	}
+
+	return conn, res.Protocol, nil
+}
+
+// returns true if the server accepted the request
+func post(addr string, path string, payload any, lat *latencies) bool {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("[ERROR]", err)
+		return false
+	}
+
+	startTimestamp := time.Now()
+	res, err := httpClient.Post("http://"+addr+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[ERROR] POST %s failed. Reason: %v\n", path, err)
+		events.inc("httpErrors")
+		return false
+	}
+	defer res.Body.Close()
+	resBody, _ := io.ReadAll(res.Body)
+	lat.add(time.Since(startTimestamp))
+
+	if res.StatusCode == http.StatusTooManyRequests {
+		events.inc("rateLimited")
+		return false
+	} else if res.StatusCode != http.StatusOK {
+		log.Printf("[ERROR] POST %s returned %d: %s\n", path, res.StatusCode, bytes.TrimSpace(resBody))
+		events.inc("httpErrors")
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// thread-safe collection of latency samples of one kind
+type latencies struct {
+	mu      sync.Mutex
+	samples []time.Duration
+}
+
+func (lat *latencies) add(sample time.Duration) {
+	lat.mu.Lock()
+	lat.samples = append(lat.samples, sample)
+	lat.mu.Unlock()
+}
+
+// returns a line of the report, e.g. "handshake  n=8  p50=1.2ms  p90=2.3ms  p99=3.1ms  max=3.4ms"
+func (lat *latencies) summary(name string) string {
+	lat.mu.Lock()
+	samples := slices.Clone(lat.samples)
+	lat.mu.Unlock()
+
+	if len(samples) == 0 {
+		return fmt.Sprintf("%-12s n=0", name)
+	}
+
+	slices.Sort(samples)
+	percentile := func(p float64) time.Duration {
+		idx := int(p * float64(len(samples)-1))
+		return samples[idx]
+	}
+
+	return fmt.Sprintf("%-12s n=%-6d p50=%-10v p90=%-10v p99=%-10v max=%v", name, len(samples), round(percentile(0.5)), round(percentile(0.9)), round(percentile(0.99)), round(samples[len(samples)-1]))
+}
+
+func round(duration time.Duration) time.Duration {
+	if duration < time.Millisecond {
+		return duration.Round(time.Microsecond)
+	}
+	return duration.Round(10 * time.Microsecond)
+}
+
+type counter struct {
+	mu    sync.Mutex
+	count map[string]int
+}
+
+func (c *counter) inc(key string) {
+	c.add(key, 1)
+}
+
+func (c *counter) add(key string, n int) {
+	c.mu.Lock()
+	if c.count == nil {
+		c.count = make(map[string]int)
+	}
+	c.count[key] += n
+	c.mu.Unlock()
+}
+
+func (c *counter) get(key string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.count[key]
+}
+
+// returns every key with its count, sorted by key
+func (c *counter) lines() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0, len(c.count))
+	for key := range c.count {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("%-14s %d", key, c.count[key]))
+	}
+	return lines
+}
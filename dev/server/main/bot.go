@@ -0,0 +1,326 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+)
+
+var validDifficulties = []string{"easy", "medium", "hard"}
+
+type botAddedPayload struct {
+	Channel    string `json:"channel"`
+	UserName   string `json:"userName"`
+	Team       string `json:"team"`
+	Striker    int    `json:"striker"`
+	Difficulty string `json:"difficulty"`
+}
+
+// adds a server-controlled player to room on behalf of its host, taking the first free striker of team
+func (room *room) addBot(hostPtr *user, team string, difficulty string) error {
+	if !slices.Contains(validDifficulties, difficulty) {
+		return fmt.Errorf("difficulty must be one of %v", validDifficulties)
+	} else if team != "left" && team != "right" {
+		return errors.New("team must be left or right")
+	}
+
+	room.mu.Lock()
+	if room.host != hostPtr {
+		room.mu.Unlock()
+		return errors.New("only the host can add bots")
+	} else if room.phase != "lobby" {
+		room.mu.Unlock()
+		return errors.New("bots can only be added between matches")
+	}
+
+	botPtr := &user{team: team, isBot: true, botDifficulty: difficulty}
+	for i := 1; botPtr.name == ""; i++ {
+		name := fmt.Sprintf("%s%d", botNamePrefix, i)
+		if _, _, err := room.members.find(name); err != nil {
+			botPtr.name = name
+		}
+	}
+	room.mu.Unlock()
+
+	availableStrikers := room.getAvailableStrikers()
+	if len(availableStrikers) == 0 {
+		return errors.New("room is full")
+	}
+	botPtr.striker = availableStrikers[0]
+
+	err := room.addMember(botPtr)
+	if err != nil {
+		return err
+	}
+	botPtr.setRoom(room, false)
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	room.resetBot(botPtr)
+	room.sendControlToAudience(botAddedPayload{Channel: "botAdded", UserName: botPtr.name, Team: team, Striker: botPtr.striker, Difficulty: difficulty})
+
+	log.Printf("[INFO] host %s added %s bot %s to %s team of room %s\n", hostPtr.name, difficulty, botPtr.name, team, room.name)
+	return nil
+}
+
+func (room *room) removeBot(hostPtr *user, botName string) error {
+	room.mu.Lock()
+	if room.host != hostPtr {
+		room.mu.Unlock()
+		return errors.New("only the host can remove bots")
+	}
+
+	_, botPtr, err := room.members.find(botName)
+	room.mu.Unlock()
+	if err != nil || !botPtr.isBot {
+		return errors.New("no such bot in this room")
+	}
+
+	err = room.deleteMember(botPtr)
+	if err != nil {
+		return err
+	}
+	botPtr.setRoom(nil, false)
+
+	log.Printf("[INFO] host %s removed bot %s from room %s\n", hostPtr.name, botName, room.name)
+	return nil
+}
+
+// only call while holding room.mu; true if room has no members left that aren't bots
+func (room *room) hasOnlyBots() bool {
+	for _, userPtr := range room.members.slice {
+		if !userPtr.isBot {
+			return false
+		}
+	}
+
+	return true
+}
+
+// only call while holding room.mu; moves botPtr back to its side's starting position
+func (room *room) resetBot(botPtr *user) {
+	if room.strikers == nil {
+		room.strikers = make(map[string]*striker, maxUsersPerRoom)
+	}
+
+	strikerPtr := &striker{yPos: boardHeight / 2}
+	if botPtr.team == "left" {
+		strikerPtr.xPos = 0.05 * boardWidth
+	} else {
+		strikerPtr.xPos = 0.95 * boardWidth
+	}
+	clampToHalf(strikerPtr, botPtr.team)
+
+	room.strikers[botPtr.name] = strikerPtr
+	room.storeBotState(botPtr, strikerPtr)
+}
+
+// only call while holding room.mu; moves every bot in room by one physics tick
+func (room *room) updateBots() {
+	for _, userPtr := range room.members.slice {
+		if !userPtr.isBot {
+			continue
+		}
+
+		strikerPtr, ok := room.strikers[userPtr.name]
+		if !ok {
+			room.resetBot(userPtr)
+			strikerPtr = room.strikers[userPtr.name]
+		}
+
+		switch userPtr.botDifficulty {
+		case "easy":
+			room.easyBotUpdate(strikerPtr, userPtr.team)
+		case "medium":
+			room.mediumBotUpdate(strikerPtr, userPtr.team)
+		case "hard":
+			room.hardBotUpdate(strikerPtr, userPtr.team)
+		}
+
+		limitStrikerSpeed(strikerPtr)
+		clampToHalf(strikerPtr, userPtr.team)
+		room.storeBotState(userPtr, strikerPtr)
+	}
+}
+
+// only call while holding room.mu; makes the bot's striker show up in snapshots like any other member's state
+func (room *room) storeBotState(botPtr *user, strikerPtr *striker) {
+	if room.latestStates == nil {
+		room.latestStates = make(map[string]*state, maxUsersPerRoom)
+	}
+
+	botStatePtr := &state{
+		Channel:    "state",
+		UserName:   botPtr.name,
+		Team:       botPtr.team,
+		Striker:    botPtr.striker,
+		PlayerXPos: toSignificantDigits(strikerPtr.xPos, boardWidth),
+		PlayerYPos: toSignificantDigits(strikerPtr.yPos, boardHeight),
+		PlayerXVel: toSignificantDigits(strikerPtr.xVel, boardWidth),
+		PlayerYVel: toSignificantDigits(strikerPtr.yVel, boardHeight),
+	}
+	room.sequenceState(botPtr, botStatePtr)
+	room.recordStrikerFrame(botPtr, botStatePtr)
+	room.latestStates[botPtr.name] = botStatePtr
+}
+
+// the bot updates below mirror easyAiUpdate(), mediumAiUpdate() and hardAiUpdate() of the client's Player.js at 60 fps
+
+func (room *room) easyBotUpdate(strikerPtr *striker, team string) {
+	puckRadius := puckRadiusFraction * boardWidth
+	strikerRadius := playerRadiusFraction * boardWidth
+
+	// wait for a human player to make first move
+	if room.puck.xPos == boardWidth/2 && room.puck.yPos == boardHeight/2 {
+		return
+	}
+
+	isPuckOnOpponentSide := team == "right" && room.puck.xPos+puckRadius < boardWidth/2 || team == "left" && boardWidth/2 < room.puck.xPos+puckRadius
+	if isPuckOnOpponentSide {
+		strikerPtr.xVel *= 0.99
+		strikerPtr.yVel *= 0.99
+		return
+	}
+
+	isPuckMovingTowardsSelfSide := team == "right" && -1 < sign(room.puck.xVel) || team == "left" && sign(room.puck.xVel) < 1
+	if isPuckMovingTowardsSelfSide {
+		dy := room.puck.yPos - strikerPtr.yPos
+		if math.Abs(dy) <= 0.8*(strikerRadius+puckRadius) {
+			strikerPtr.yVel *= 0.99
+		} else {
+			strikerPtr.yVel = 0.2 * dy
+		}
+	}
+
+	strikerPtr.xPos = 0.1 * boardWidth
+	if team == "right" {
+		strikerPtr.xPos = 0.9 * boardWidth
+	}
+	strikerPtr.yPos += strikerPtr.yVel
+
+	strikerPtr.xVel = towardsOpponent(team) * max(botReplySpeed, math.Abs(strikerPtr.xVel))
+}
+
+func (room *room) mediumBotUpdate(strikerPtr *striker, team string) {
+	puckRadius := puckRadiusFraction * boardWidth
+	strikerRadius := playerRadiusFraction * boardWidth
+
+	// wait for a human player to make first move
+	if room.puck.xPos == boardWidth/2 && room.puck.yPos == boardHeight/2 {
+		return
+	}
+
+	isPuckOnOpponentSide := team == "right" && room.puck.xPos+puckRadius < boardWidth/2 || team == "left" && boardWidth/2 < room.puck.xPos+puckRadius
+	isPuckBtwStrikerAndGoal := team == "right" && strikerPtr.xPos < room.puck.xPos || team == "left" && room.puck.xPos < strikerPtr.xPos
+	isPuckMovingAwayFromGoal := team == "right" && sign(room.puck.xVel) == -1 || team == "left" && sign(room.puck.xVel) == 1
+
+	if isPuckOnOpponentSide || isPuckBtwStrikerAndGoal {
+		// fall back to guard own goal
+		multiplier := 0.1
+		if isPuckOnOpponentSide {
+			multiplier = 0.05
+		}
+
+		goalX := boardWidth*xBoardRinkFraction + strikerRadius
+		if team == "right" {
+			goalX = boardWidth*(1-xBoardRinkFraction) - strikerRadius
+		}
+		strikerPtr.xVel = multiplier * (goalX - strikerPtr.xPos)
+		strikerPtr.yVel = multiplier * (boardHeight/2 - strikerPtr.yPos)
+	} else if isPuckMovingAwayFromGoal && botReplySpeed < math.Abs(room.puck.xVel) {
+		// work is done, so just chill
+		strikerPtr.xVel *= 0.99
+		strikerPtr.yVel *= 0.99
+	} else {
+		// chase puck, accelerating along x to hit it towards opponent's side and aiming along y with varying precision
+		dx := room.puck.xPos - strikerPtr.xPos
+		dy := room.puck.yPos - strikerPtr.yPos
+
+		yMultiplier := 0.1
+		random := rand.Float64()
+		if random < 0.5 {
+			yMultiplier = 0.35
+		} else if random <= 0.8 {
+			yMultiplier = 0.2
+		}
+
+		strikerPtr.xVel += 0.0075 * dx
+		strikerPtr.yVel = yMultiplier * dy
+	}
+
+	strikerPtr.xPos += strikerPtr.xVel
+	strikerPtr.yPos += strikerPtr.yVel
+}
+
+func (room *room) hardBotUpdate(strikerPtr *striker, team string) {
+	if room.isGoal {
+		return
+	}
+
+	puckRadius := puckRadiusFraction * boardWidth
+	strikerRadius := playerRadiusFraction * boardWidth
+
+	x := 0.1 * boardWidth
+	if team == "right" {
+		x = 0.9 * boardWidth
+	}
+	diff := boardHeight/2 - room.puck.yPos
+	maxDiff := (boardHeight*(1-2*yBoardRinkFraction) - 2*strikerRadius) / 2
+	y := room.puck.yPos + puckRadius*diff/maxDiff
+
+	// accelerate towards (x, y)
+	strikerPtr.xVel = 0.35 * (x - strikerPtr.xPos)
+	strikerPtr.yVel = 0.35 * (y - strikerPtr.yPos)
+	limitStrikerSpeed(strikerPtr)
+	strikerPtr.xPos += strikerPtr.xVel
+	strikerPtr.yPos += strikerPtr.yVel
+
+	towardsPuckY := 1.0
+	if room.puck.yPos < strikerPtr.yPos {
+		towardsPuckY = -1
+	}
+	strikerPtr.xVel = towardsOpponent(team) * max(botReplySpeed, math.Abs(strikerPtr.xVel))
+	strikerPtr.yVel = towardsPuckY * max(botReplySpeed, math.Abs(strikerPtr.yVel))
+}
+
+// keeps striker within its team's half of the rink
+func clampToHalf(strikerPtr *striker, team string) {
+	radius := playerRadiusFraction * boardWidth
+
+	if team == "left" {
+		strikerPtr.xPos = clamp(xBoardRinkFraction*boardWidth+radius, strikerPtr.xPos, boardWidth/2-radius)
+	} else {
+		strikerPtr.xPos = clamp(boardWidth/2+radius, strikerPtr.xPos, boardWidth*(1-xBoardRinkFraction)-radius)
+	}
+	strikerPtr.yPos = clamp(yBoardRinkFraction*boardHeight+radius, strikerPtr.yPos, boardHeight*(1-yBoardRinkFraction)-radius)
+}
+
+func limitStrikerSpeed(strikerPtr *striker) {
+	strikerPtr.xVel = clamp(-strikerMaxSpeed, strikerPtr.xVel, strikerMaxSpeed)
+	strikerPtr.yVel = clamp(-strikerMaxSpeed, strikerPtr.yVel, strikerMaxSpeed)
+}
+
+func towardsOpponent(team string) float64 {
+	if team == "right" {
+		return -1
+	}
+	return 1
+}
+
+func sign(value float64) float64 {
+	if value < 0 {
+		return -1
+	} else if 0 < value {
+		return 1
+	}
+	return 0
+}
+
+func isBotName(userName string) bool {
+	return strings.HasPrefix(userName, botNamePrefix)
+}
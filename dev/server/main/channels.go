@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type errorPayload struct {
+	Channel string `json:"channel"`
+	Message string `json:"message"`
+}
+
+// dispatches a web socket message received after the handshake to the handler of its channel
+func handleMessage(currUser *user, data []byte) error {
+	type channelPayload struct {
+		Channel string `json:"channel"`
+	}
+
+	var header channelPayload
+	err := json.Unmarshal(data, &header)
+	if err != nil {
+		return err
+	}
+
+	switch header.Channel {
+	case "state":
+		var newState state
+		err := json.Unmarshal(data, &newState)
+		if err != nil {
+			return err
+		}
+
+		// spectators receive the state stream but don't contribute to it
+		roomPtr, isSpectator := currUser.getRoom()
+		if roomPtr == nil || isSpectator {
+			return nil
+		}
+
+		isForwarded, err := roomPtr.acceptState(currUser, &newState)
+		if err != nil || !isForwarded {
+			return err
+		}
+		roomPtr.forwardState(&newState)
+
+	case "ack":
+		type ackReqPayload struct {
+			Tick uint64 `json:"tick"`
+		}
+
+		var payload ackReqPayload
+		err := json.Unmarshal(data, &payload)
+		if err != nil {
+			return err
+		}
+
+		// spectators acknowledge snapshots too
+		roomPtr, _ := currUser.getRoom()
+		if roomPtr == nil {
+			return errors.New("user is not in a room")
+		}
+		roomPtr.ack(currUser, payload.Tick)
+
+	case "timeSync":
+		type timeSyncReqPayload struct {
+			ClientTime float64 `json:"clientTime"`
+		}
+
+		var payload timeSyncReqPayload
+		err := json.Unmarshal(data, &payload)
+		if err != nil {
+			return err
+		}
+		return currUser.syncTime(payload.ClientTime)
+
+	case "hit":
+		type hitReqPayload struct {
+			Seq        uint64 `json:"seq"`        // state whose striker hit the puck
+			ServerTime int64  `json:"serverTime"` // unix time in milliseconds of the puck the client saw, according to its synced clock
+		}
+
+		var payload hitReqPayload
+		err := json.Unmarshal(data, &payload)
+		if err != nil {
+			return err
+		}
+
+		roomPtr, err := getPlayingRoom(currUser)
+		if err != nil {
+			return err
+		}
+		return currUser.sendControl(roomPtr.arbitrateHit(currUser, payload.Seq, time.UnixMilli(payload.ServerTime)))
+
+	case "ready":
+		type readyReqPayload struct {
+			IsReady bool `json:"isReady"`
+		}
+
+		var payload readyReqPayload
+		err := json.Unmarshal(data, &payload)
+		if err != nil {
+			return err
+		}
+
+		roomPtr, err := getPlayingRoom(currUser)
+		if err != nil {
+			return err
+		}
+		return roomPtr.setReady(currUser, payload.IsReady)
+
+	case "start":
+		roomPtr, err := getPlayingRoom(currUser)
+		if err != nil {
+			return err
+		}
+		return roomPtr.startMatch(currUser)
+
+	case "pause":
+		roomPtr, err := getPlayingRoom(currUser)
+		if err != nil {
+			return err
+		}
+		return roomPtr.pause(currUser)
+
+	case "resume":
+		roomPtr, err := getPlayingRoom(currUser)
+		if err != nil {
+			return err
+		}
+		return roomPtr.resumeByUser(currUser)
+
+	case "chat":
+		type chatReqPayload struct {
+			Text      string `json:"text"`
+			QuickChat string `json:"quickChat"`
+		}
+
+		var payload chatReqPayload
+		err := json.Unmarshal(data, &payload)
+		if err != nil {
+			return err
+		}
+
+		// spectators may chat too
+		roomPtr, _ := currUser.getRoom()
+		if roomPtr == nil {
+			return errors.New("user is not in a room")
+		}
+		return roomPtr.chat(currUser, payload.Text, payload.QuickChat)
+
+	case "switch":
+		type switchReqPayload struct {
+			Team    string `json:"team"`
+			Striker int    `json:"striker"`
+		}
+
+		var payload switchReqPayload
+		err := json.Unmarshal(data, &payload)
+		if err != nil {
+			return err
+		}
+
+		roomPtr, err := getPlayingRoom(currUser)
+		if err != nil {
+			return err
+		}
+		return roomPtr.switchSides(currUser, payload.Team, payload.Striker)
+
+	case "queue":
+		type queueReqPayload struct {
+			Mode string `json:"mode"`
+		}
+
+		var payload queueReqPayload
+		err := json.Unmarshal(data, &payload)
+		if err != nil {
+			return err
+		}
+		return matchmaking.enqueue(currUser, payload.Mode)
+
+	case "cancelQueue":
+		return matchmaking.cancel(currUser)
+
+	case "addBot":
+		type addBotReqPayload struct {
+			Team       string `json:"team"`
+			Difficulty string `json:"difficulty"`
+		}
+
+		var payload addBotReqPayload
+		err := json.Unmarshal(data, &payload)
+		if err != nil {
+			return err
+		}
+
+		roomPtr, err := getPlayingRoom(currUser)
+		if err != nil {
+			return err
+		}
+		return roomPtr.addBot(currUser, payload.Team, payload.Difficulty)
+
+	case "removeBot":
+		type removeBotReqPayload struct {
+			UserName string `json:"userName"`
+		}
+
+		var payload removeBotReqPayload
+		err := json.Unmarshal(data, &payload)
+		if err != nil {
+			return err
+		}
+
+		roomPtr, err := getPlayingRoom(currUser)
+		if err != nil {
+			return err
+		}
+		return roomPtr.removeBot(currUser, payload.UserName)
+
+	case "kick", "ban":
+		type kickReqPayload struct {
+			UserName string `json:"userName"`
+		}
+
+		var payload kickReqPayload
+		err := json.Unmarshal(data, &payload)
+		if err != nil {
+			return err
+		}
+
+		roomPtr, err := getPlayingRoom(currUser)
+		if err != nil {
+			return err
+		}
+		return roomPtr.kick(currUser, payload.UserName, header.Channel == "ban")
+
+	case "lock":
+		type lockReqPayload struct {
+			IsLocked bool `json:"isLocked"`
+		}
+
+		var payload lockReqPayload
+		err := json.Unmarshal(data, &payload)
+		if err != nil {
+			return err
+		}
+
+		roomPtr, err := getPlayingRoom(currUser)
+		if err != nil {
+			return err
+		}
+		return roomPtr.setLocked(currUser, payload.IsLocked)
+
+	case "transferHost":
+		type transferHostReqPayload struct {
+			UserName string `json:"userName"`
+		}
+
+		var payload transferHostReqPayload
+		err := json.Unmarshal(data, &payload)
+		if err != nil {
+			return err
+		}
+
+		roomPtr, err := getPlayingRoom(currUser)
+		if err != nil {
+			return err
+		}
+		return roomPtr.transferHost(currUser, payload.UserName)
+
+	default:
+		return fmt.Errorf("unknown channel %q", header.Channel)
+	}
+
+	return nil
+}
+
+// util functions: not meant to be used outside this file
+func getPlayingRoom(currUser *user) (*room, error) {
+	roomPtr, isSpectator := currUser.getRoom()
+	if roomPtr == nil || isSpectator {
+		return nil, errors.New("user is not playing in a room")
+	}
+
+	return roomPtr, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+type chatMessage struct {
+	Channel     string `json:"channel"`
+	UserName    string `json:"userName"`
+	Text        string `json:"text"`
+	QuickChat   string `json:"quickChat,omitempty"` // quick-chat code, lets clients show their own translation of text
+	IsSpectator bool   `json:"isSpectator"`
+	ServerTime  int64  `json:"serverTime"` // unix time in milliseconds at which the message was sent
+}
+
+type chatHistoryPayload struct {
+	Channel  string        `json:"channel"`
+	Messages []chatMessage `json:"messages"`
+}
+
+// predefined messages for players who can't type mid-game, keyed by quick-chat code
+var quickChats = map[string]string{
+	"hello":    "Hello!",
+	"gg":       "Good game!",
+	"niceShot": "Nice shot!",
+	"niceSave": "Nice save!",
+	"wow":      "Wow!",
+	"oops":     "Oops!",
+	"thanks":   "Thanks!",
+	"sorry":    "Sorry!",
+	"rematch":  "Rematch?",
+	"brb":      "Be right back",
+}
+
+var profanityRegexp = regexp.MustCompile(`(?i)\b(fuck|fucks|fucked|fucker|fucking|shit|shits|shitty|bitch|bitches|bastard|bastards|asshole|assholes|dick|dicks|cunt|cunts|motherfucker|motherfuckers|wanker|wankers)\b`)
+
+// masks every profane word of text with asterisks
+func filterProfanity(text string) string {
+	return profanityRegexp.ReplaceAllStringFunc(text, func(word string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	})
+}
+
+// validates and cleans up text typed by a user
+func sanitizeChatText(text string) (string, error) {
+	if !utf8.ValidString(text) {
+		return "", errors.New("chat message is not valid UTF-8")
+	}
+
+	text = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+	text = strings.TrimSpace(text)
+
+	if text == "" {
+		return "", errors.New("chat message is empty")
+	} else if maxChatLength < utf8.RuneCountInString(text) {
+		return "", fmt.Errorf("chat message must not be longer than %v characters", maxChatLength)
+	}
+
+	return filterProfanity(text), nil
+}
+
+// relays a chat message from userPtr to everyone in the room; a quick-chat code takes precedence over text
+func (room *room) chat(userPtr *user, text string, quickChat string) error {
+	if quickChat != "" {
+		var ok bool
+		text, ok = quickChats[quickChat]
+		if !ok {
+			return fmt.Errorf("unknown quick-chat code %q", quickChat)
+		}
+	} else {
+		var err error
+		text, err = sanitizeChatText(text)
+		if err != nil {
+			return err
+		}
+	}
+
+	if !userPtr.chatLimiter.isAllowed() {
+		return errors.New("sending chat messages too fast")
+	}
+	_, isSpectator := userPtr.getRoom()
+
+	message := chatMessage{
+		Channel:     "chat",
+		UserName:    userPtr.name,
+		Text:        text,
+		QuickChat:   quickChat,
+		IsSpectator: isSpectator,
+		ServerTime:  time.Now().UnixMilli(),
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	room.chatHistory = append(room.chatHistory, message)
+	if chatHistorySize < len(room.chatHistory) {
+		room.chatHistory = room.chatHistory[len(room.chatHistory)-chatHistorySize:]
+	}
+
+	room.sendControlToAudience(message)
+	return nil
+}
+
+// sends the room's recent chat messages to a user who just joined, started spectating or resumed their session
+func (room *room) sendChatHistory(userPtr *user) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if len(room.chatHistory) == 0 {
+		return
+	}
+
+	err := userPtr.sendControl(chatHistoryPayload{Channel: "chatHistory", Messages: room.chatHistory})
+	if err != nil {
+		log.Printf("[ERROR] could not send chat history of room %s to user %s. Reason: %v\n", room.name, userPtr.name, err)
+	}
+}
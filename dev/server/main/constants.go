@@ -2,18 +2,127 @@ package main
 
 const (
 	// web socket
-	webSocketReadLimit = 1024 // max allowed message size = 1024 bytes = 1 KB
-	webSocketTimeout   = 60   // measured in seconds
+	webSocketReadLimit = 1024         // max allowed message size = 1024 bytes = 1 KB
+	webSocketTimeout   = 60           // measured in seconds
+	webSocketWriteWait = 10           // measured in seconds
+	stateQueueSize     = 8            // max state messages waiting to be written to a user
+	controlQueueSize   = 32           // max control messages waiting to be written to a user
+	stateQueuePolicy   = "dropOldest" // what to do when a user's state queue is full: "dropOldest" or "disconnect"
+
+	// latency
+	pingInterval = 2 // measured in seconds, also how often round-trip time is sampled
+
+	// web socket message limiting
+	messagesPerSecond     = 3 * physicsTickRate // max messages read per connection, leaving room for clients rendering faster than 60 fps
+	messageBurst          = messagesPerSecond
+	maxDroppedMessages    = messagesPerSecond // excess messages tolerated per droppedMessagesWindow before disconnecting
+	droppedMessagesWindow = 10                // measured in seconds
+	stateErrorInterval    = 1                 // invalid states are reported back at most once per interval, measured in seconds
 
 	// user
-	maxUserNameLength = 10
-	maxUserCount      = maxRoomCount * maxUsersPerRoom
+	maxUserNameLength    = 10
+	maxUserCount         = maxRoomCount * (maxUsersPerRoom + maxSpectatorsPerRoom)
+	sessionTokenLength   = 16 // measured in bytes
+	reconnectGracePeriod = 30 // measured in seconds
 
 	// room
-	maxRoomNameLength = 10
-	maxRoomCount      = 16
-	maxUsersPerRoom   = 4
-	maxUsersPerTeam   = 2
+	maxRoomNameLength    = 10
+	maxRoomCount         = 16
+	maxUsersPerRoom      = 4
+	maxUsersPerTeam      = 2
+	maxSpectatorsPerRoom = 8
+	maxPasswordLength    = 32
+	inviteCodeLength     = 6
+	defaultTickRate      = 30 // measured in snapshots per second
+	minTickRate          = 10 // measured in snapshots per second
+	maxTickRate          = physicsTickRate
+	deltaHistorySize     = 64  // snapshots kept to compute deltas against, measured in ticks
+	keyframeInterval     = 300 // max ticks between full snapshots sent to clients that receive deltas
+
+	// match
+	matchCountdownDuration   = 3000 // measured in milliseconds
+	kickoffCountdownDuration = 1000 // measured in milliseconds
+	maxGoalLimit             = 99
+	maxTimeLimit             = 60 * 60 // measured in seconds
+	defaultPauseBudget       = 2       // pauses allowed per player per match
+	maxPauseBudget           = 10
+	defaultPauseTimeout      = 30  // measured in seconds
+	maxPauseTimeout          = 120 // measured in seconds
+
+	// board (reference dimensions for server-side physics, mirrors the 16:9 board drawn by the client)
+	boardWidth           = 1600.0 // measured in px
+	boardHeight          = 900.0  // measured in px
+	xBoardRinkFraction   = 0.008
+	yBoardRinkFraction   = 0.014
+	yGoalStartFraction   = 320.0 / 900
+	yGoalEndFraction     = 580.0 / 900
+	puckRadiusFraction   = 0.015
+	playerRadiusFraction = 0.0225
+	truncateFloatFactor  = 1000 // positions and velocities travel over the wire as floor(fraction * truncateFloatFactor)
+
+	// physics (same values as the client's global.js and Puck.js)
+	physicsTickRate             = 60    // measured in ticks per second
+	puckMinSpeed                = 1     // measured in px/tick
+	puckMaxSpeed                = 20    // measured in px/tick
+	puckFriction                = 0.999 // fraction of speed retained after deceleration caused by friction
+	goalThresholdSpeed          = 7     // measured in px/tick
+	goalResetDelay              = 2000  // measured in milliseconds
+	stuckPuckMaxDuration        = 10    // measured in seconds
+	puckPlayerCollisionCooldown = 150   // measured in milliseconds
+
+	// lag compensation
+	maxRewindDuration  = 200 // how far back a reported hit can be replayed, measured in milliseconds
+	puckHistorySize    = maxRewindDuration*physicsTickRate/1000 + 2
+	strikerHistorySize = 32  // states remembered per member, enough for maxRewindDuration at up to 150 states per second
+	hitTolerance       = 4.0 // extra distance allowed between striker and puck to absorb the rounding of positions, measured in px
+	hitJitterFactor    = 4   // multiples of a member's jitter, on top of a tick, by which the timing of their hits may be off
+
+	// profiles
+	minSecretLength = 8
+	maxSecretLength = 64
+	saltLength      = 16 // measured in bytes
+	initialRating   = 1500
+	ratingKFactor   = 32 // max rating change per match
+
+	// profile storage
+	maxProfileCount  = 10000
+	profileSaveDelay = 5 // changes are written to disk at most this often, measured in seconds
+
+	// secret hashing, argon2id with the parameters owasp recommends
+	argon2Time      = 2
+	argon2Memory    = 19 * 1024 // measured in KiB
+	argon2Threads   = 1
+	argon2KeyLength = 32 // measured in bytes
+
+	// history
+	defaultPageSize = 20
+	maxPageSize     = 100
+
+	// recording
+	recorderQueueSize = 256 // max states waiting to be written to a recording
+	minReplaySpeed    = 0.25
+	maxReplaySpeed    = 8.0
+
+	// recording storage
+	maxRecordingFrameSize    = 16 * 1024 // max size of a recorded snapshot, measured in bytes
+	maxRecordingAge          = 30        // measured in days
+	maxRecordingCount        = 1000      // newest recordings kept, older ones are deleted
+	recordingPruneInterval   = 3600      // measured in seconds
+	privateRecordingIdLength = 16        // random bytes in the id of a recording of a private room, measured in bytes
+
+	// bots
+	botNamePrefix   = "bot-"
+	botReplySpeed   = 10 // min speed at which bots hit the puck, measured in px/tick
+	strikerMaxSpeed = 30 // measured in px/tick
+
+	// matchmaking
+	matchmakingTimeout = 60 // measured in seconds
+
+	// chat
+	maxChatLength         = webSocketReadLimit / 4 // measured in runes; leaves room for the json envelope and multi-byte characters
+	chatHistorySize       = 20
+	chatMessagesPerWindow = 5
+	chatRateWindow        = 5 // measured in seconds
 
 	// rate limiting
 	reqCountPerBrowserVisit = 25
@@ -21,6 +130,11 @@ const (
 	reqPerMinute            = 10 * reqCountPerBrowserVisit
 	reqPerHour              = 10 * reqPerMinute
 	reqPerDay               = 2 * reqPerHour
+	ipBurst                 = 2 * reqCountPerBrowserVisit // max requests a single ip can make at once
+	ipReqPerSecond          = 2                           // rate at which an ip regains requests
+	userBurst               = 10                          // max handshakes a single user name can make at once
+	userReqPerSecond        = 0.5                         // rate at which a user name regains handshakes
+	rateLimiterIdleTimeout  = 10 * 60                     // measured in seconds
 
 	// memory limiting
 	maxPayloadSize       = 1024                  // max allowed payload size = 1024 bytes = 1 KB
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"slices"
+)
+
+// clients that opt into delta snapshots acknowledge the snapshots they apply and then only receive the fields
+// that changed since their latest acknowledged snapshot; they get a full snapshot, the keyframe, whenever that
+// snapshot is no longer stored or keyframeInterval ticks have passed since their last keyframe
+type deltaSnapshot struct {
+	Channel  string           `json:"channel"`
+	Tick     uint64           `json:"tick"`
+	BaseTick uint64           `json:"baseTick"`
+	Phase    string           `json:"phase"`
+	States   []map[string]any `json:"states"`            // changed fields of each state along with its userName, unchanged states are left out
+	Removed  []string         `json:"removed,omitempty"` // user names whose states are no longer part of the snapshot
+}
+
+type snapshotFrame struct {
+	tick   uint64
+	states map[string]state
+}
+
+// only call while holding room.mu; keeps a copy of currSnapshot's states to compute later deltas against
+func (room *room) storeSnapshotFrame(currSnapshot *snapshot) {
+	states := make(map[string]state, len(currSnapshot.States))
+	for _, currStatePtr := range currSnapshot.States {
+		states[currStatePtr.UserName] = *currStatePtr
+	}
+
+	room.snapshotFrames[currSnapshot.Tick%deltaHistorySize] = snapshotFrame{tick: currSnapshot.Tick, states: states}
+}
+
+// only call while holding room.mu; returns false if the snapshot of tick was never stored or has been overwritten
+func (room *room) snapshotFrame(tick uint64) (snapshotFrame, bool) {
+	frame := room.snapshotFrames[tick%deltaHistorySize]
+	return frame, tick != 0 && frame.tick == tick
+}
+
+// only call while holding room.mu; sends currSnapshot to the audience, encoding each delta once per base tick
+func (room *room) sendSnapshot(currSnapshot *snapshot, fullData []byte) {
+	deltas := make(map[uint64][]byte)
+
+	for _, userPtr := range room.audience() {
+		if !userPtr.wantsDeltas {
+			userPtr.sendState(fullData)
+			continue
+		}
+
+		baseFrame, ok := room.snapshotFrame(userPtr.ackedTick)
+		if !ok || keyframeInterval <= currSnapshot.Tick-userPtr.keyframeTick {
+			userPtr.keyframeTick = currSnapshot.Tick
+			userPtr.sendState(fullData)
+			continue
+		}
+
+		data, ok := deltas[baseFrame.tick]
+		if !ok {
+			var err error
+			data, err = json.Marshal(diffSnapshot(baseFrame, currSnapshot))
+			if err != nil {
+				log.Printf("[ERROR] error encoding delta snapshot of room %s. Reason: %v\n", room.name, err)
+				userPtr.sendState(fullData)
+				continue
+			}
+			deltas[baseFrame.tick] = data
+		}
+		userPtr.sendState(data)
+	}
+}
+
+// records that userPtr applied the snapshot of tick; stale and unknown ticks are ignored, so the baseline only moves forward
+func (room *room) ack(userPtr *user, tick uint64) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if tick <= userPtr.ackedTick {
+		return
+	} else if _, ok := room.snapshotFrame(tick); !ok {
+		return
+	}
+
+	userPtr.ackedTick = tick
+}
+
+// the resumed client lost its snapshots along with its previous connection, so start over with a keyframe
+func (room *room) resumeSnapshots(userPtr *user, wantsDeltas bool) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	userPtr.wantsDeltas = wantsDeltas
+	userPtr.stateStream = stateStream{} // the client may number the states of its new connection from scratch
+	room.resetSnapshotBaseline(userPtr)
+}
+
+// only call while holding room.mu; the next snapshot sent to userPtr will be a keyframe
+func (room *room) resetSnapshotBaseline(userPtr *user) {
+	userPtr.ackedTick = 0
+	userPtr.keyframeTick = 0
+}
+
+func diffSnapshot(baseFrame snapshotFrame, currSnapshot *snapshot) deltaSnapshot {
+	delta := deltaSnapshot{Channel: "snapshot", Tick: currSnapshot.Tick, BaseTick: baseFrame.tick, Phase: currSnapshot.Phase, States: make([]map[string]any, 0, len(currSnapshot.States))}
+
+	for _, currStatePtr := range currSnapshot.States {
+		var basePtr *state
+		if baseState, ok := baseFrame.states[currStatePtr.UserName]; ok {
+			basePtr = &baseState
+		}
+
+		changes := diffState(basePtr, currStatePtr)
+		if basePtr == nil || 1 < len(changes) {
+			delta.States = append(delta.States, changes)
+		}
+	}
+
+	for userName := range baseFrame.states {
+		isRemoved := !slices.ContainsFunc(currSnapshot.States, func(currStatePtr *state) bool {
+			return currStatePtr.UserName == userName
+		})
+		if isRemoved {
+			delta.Removed = append(delta.Removed, userName)
+		}
+	}
+	slices.Sort(delta.Removed)
+
+	return delta
+}
+
+// returns the fields of currStatePtr that differ from basePtr along with its userName, or every field if basePtr is nil
+func diffState(basePtr *state, currStatePtr *state) map[string]any {
+	changes := map[string]any{"userName": currStatePtr.UserName}
+	isNew := basePtr == nil
+	if isNew {
+		basePtr = &state{}
+	}
+
+	set := func(key string, isChanged bool, value any) {
+		if isNew || isChanged {
+			changes[key] = value
+		}
+	}
+
+	set("channel", basePtr.Channel != currStatePtr.Channel, currStatePtr.Channel)
+	set("isHost", basePtr.IsHost != currStatePtr.IsHost, currStatePtr.IsHost)
+	set("team", basePtr.Team != currStatePtr.Team, currStatePtr.Team)
+	set("striker", basePtr.Striker != currStatePtr.Striker, currStatePtr.Striker)
+	set("playerXPos", basePtr.PlayerXPos != currStatePtr.PlayerXPos, currStatePtr.PlayerXPos)
+	set("playerYPos", basePtr.PlayerYPos != currStatePtr.PlayerYPos, currStatePtr.PlayerYPos)
+	set("playerXVel", basePtr.PlayerXVel != currStatePtr.PlayerXVel, currStatePtr.PlayerXVel)
+	set("playerYVel", basePtr.PlayerYVel != currStatePtr.PlayerYVel, currStatePtr.PlayerYVel)
+	set("puckXPos", basePtr.PuckXPos != currStatePtr.PuckXPos, currStatePtr.PuckXPos)
+	set("puckYPos", basePtr.PuckYPos != currStatePtr.PuckYPos, currStatePtr.PuckYPos)
+	set("puckXVel", basePtr.PuckXVel != currStatePtr.PuckXVel, currStatePtr.PuckXVel)
+	set("puckYVel", basePtr.PuckYVel != currStatePtr.PuckYVel, currStatePtr.PuckYVel)
+	set("leftScore", basePtr.LeftScore != currStatePtr.LeftScore, currStatePtr.LeftScore)
+	set("rightScore", basePtr.RightScore != currStatePtr.RightScore, currStatePtr.RightScore)
+	set("seq", basePtr.Seq != currStatePtr.Seq, currStatePtr.Seq)
+	set("serverTime", basePtr.ServerTime != currStatePtr.ServerTime, currStatePtr.ServerTime)
+
+	return changes
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+// applies delta to the states of baseFrame the way the client does, returning the resulting states by user name
+func applyDelta(t *testing.T, baseFrame snapshotFrame, delta deltaSnapshot) map[string]state {
+	t.Helper()
+
+	// the delta travels as json, so apply what the client would receive
+	data, err := json.Marshal(delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received deltaSnapshot
+	err = json.Unmarshal(data, &received)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	states := make(map[string]state, len(baseFrame.states))
+	for userName, baseState := range baseFrame.states {
+		states[userName] = baseState
+	}
+	for _, userName := range received.Removed {
+		delete(states, userName)
+	}
+
+	for _, changes := range received.States {
+		userName, _ := changes["userName"].(string)
+		fields := make(map[string]any)
+		if baseState, ok := states[userName]; ok {
+			baseData, err := json.Marshal(baseState)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = json.Unmarshal(baseData, &fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for key, value := range changes {
+			fields[key] = value
+		}
+
+		fieldData, err := json.Marshal(fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var newState state
+		err = json.Unmarshal(fieldData, &newState)
+		if err != nil {
+			t.Fatal(err)
+		}
+		states[userName] = newState
+	}
+
+	return states
+}
+
+func TestDiffSnapshotRebuildsSnapshot(t *testing.T) {
+	moving := state{Channel: "state", UserName: "jomin", Team: "left", PlayerXPos: 300, PlayerYPos: 500, PuckXPos: 500, Seq: 7, ServerTime: 1760000000000}
+	still := state{Channel: "state", UserName: "suhan", Team: "right", Striker: 1, PlayerXPos: 700, PlayerYPos: 500, PuckXPos: 500, Seq: 3, ServerTime: 1760000000000}
+	leaving := state{Channel: "state", UserName: "minji", Team: "left", Striker: 2, PlayerXPos: 100, Seq: 9}
+	baseFrame := snapshotFrame{tick: 10, states: map[string]state{"jomin": moving, "suhan": still, "minji": leaving}}
+
+	movedState := moving
+	movedState.PlayerXPos = 320
+	movedState.PlayerXVel = 20
+	movedState.Seq = 8
+	stillState := still
+	joinedState := state{Channel: "state", UserName: "bot-1", Team: "right", Striker: 3, PlayerXPos: 900, Seq: 1}
+	currSnapshot := &snapshot{Channel: "snapshot", Tick: 12, Phase: "playing", States: []*state{&movedState, &stillState, &joinedState}}
+
+	delta := diffSnapshot(baseFrame, currSnapshot)
+	if delta.Tick != 12 || delta.BaseTick != 10 || delta.Phase != "playing" {
+		t.Fatalf("got tick %d, base tick %d and phase %q, want 12, 10 and playing", delta.Tick, delta.BaseTick, delta.Phase)
+	} else if !slices.Equal(delta.Removed, []string{"minji"}) {
+		t.Fatalf("got removed %v, want [minji]", delta.Removed)
+	}
+
+	// unchanged states are left out, changed ones only carry what changed
+	if len(delta.States) != 2 {
+		t.Fatalf("got %d states, want the moved and the joined one", len(delta.States))
+	}
+	wantKeys := []string{"playerXPos", "playerXVel", "seq", "userName"}
+	gotKeys := make([]string, 0, len(delta.States[0]))
+	for key := range delta.States[0] {
+		gotKeys = append(gotKeys, key)
+	}
+	slices.Sort(gotKeys)
+	if !slices.Equal(gotKeys, wantKeys) {
+		t.Fatalf("got changed fields %v, want %v", gotKeys, wantKeys)
+	}
+
+	states := applyDelta(t, baseFrame, delta)
+	if len(states) != len(currSnapshot.States) {
+		t.Fatalf("got %d states after applying the delta, want %d", len(states), len(currSnapshot.States))
+	}
+	for _, currStatePtr := range currSnapshot.States {
+		if got := states[currStatePtr.UserName]; got != *currStatePtr {
+			t.Errorf("got %+v after applying the delta, want %+v", got, *currStatePtr)
+		}
+	}
+}
+
+func TestSnapshotFrameExpires(t *testing.T) {
+	room := &room{}
+	room.storeSnapshotFrame(&snapshot{Tick: 5})
+
+	if _, ok := room.snapshotFrame(5); !ok {
+		t.Fatal("stored snapshot was not found")
+	}
+	if _, ok := room.snapshotFrame(0); ok {
+		t.Fatal("tick 0 was found, but it is never stored")
+	}
+
+	room.storeSnapshotFrame(&snapshot{Tick: 5 + deltaHistorySize})
+	if _, ok := room.snapshotFrame(5); ok {
+		t.Fatal("overwritten snapshot was still found")
+	}
+}
+
+func TestAckOnlyMovesForward(t *testing.T) {
+	room := &room{}
+	userPtr := &user{name: "jomin"}
+	room.storeSnapshotFrame(&snapshot{Tick: 3})
+	room.storeSnapshotFrame(&snapshot{Tick: 4})
+
+	room.ack(userPtr, 4)
+	room.ack(userPtr, 3)
+	room.ack(userPtr, 9)
+	if userPtr.ackedTick != 4 {
+		t.Fatalf("got acked tick %d, want 4", userPtr.ackedTick)
+	}
+}
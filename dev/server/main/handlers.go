@@ -4,11 +4,19 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"goal/codec"
+
 	"github.com/gorilla/websocket"
 )
 
@@ -26,7 +34,11 @@ func rootHandler(writer http.ResponseWriter, req *http.Request) {
 }
 
 func createUserHandler(writer http.ResponseWriter, req *http.Request) {
-	currUser := user{}
+	currUser := &user{
+		stateQueue:   make(chan []byte, stateQueueSize),
+		controlQueue: make(chan []byte, controlQueueSize),
+		chatLimiter:  rateLimiter{totalAllowed: chatMessagesPerWindow, windowDuration: chatRateWindow * time.Second},
+	}
 
 	// upgrade http to websocket
 	conn, err := upgrader.Upgrade(writer, req, nil)
@@ -39,7 +51,9 @@ func createUserHandler(writer http.ResponseWriter, req *http.Request) {
 	// set websocket connection guard parameters
 	conn.SetReadLimit(webSocketReadLimit)
 	conn.SetReadDeadline(time.Now().Add(webSocketTimeout * time.Second))
-	conn.SetPongHandler(func(string) error {
+	var pendingPing atomic.Int64 // payload of the latest ping, whose pong is timed to measure latency
+	conn.SetPongHandler(func(appData string) error {
+		currUser.recordPong(appData, &pendingPing)
 		return conn.SetReadDeadline(time.Now().Add(webSocketTimeout * time.Second)) // this pong handler works along with pingPeriodically() goroutine to ensure that dead connections to unreachable clients are disconnected within webSocketTimeout number of seconds
 	})
 
@@ -64,32 +78,43 @@ func createUserHandler(writer http.ResponseWriter, req *http.Request) {
 		if err == nil {
 			// server was able to close connection; this means that client has not yet closed connection, so websocket connection was closed from server-side
 			log.Println("[INFO] websocket connection closed by server")
+		} else if errors.Is(err, net.ErrClosed) {
+			// connection was already closed by user.disconnect()
 		} else if err != websocket.ErrCloseSent {
 			log.Println("[ERROR] error while closing websocket from server-side. Reason:", err)
 		} else if err == websocket.ErrCloseSent {
 			// log.Println("[INFO] websocket connection already closed by client")
 		}
 
-		cleanupPostDisconnect(&currUser, terminateChannel, &waitGroup)
+		cleanupPostDisconnect(currUser, conn, terminateChannel, &waitGroup)
 	}()
 
 	// start goroutine to parallely keep pinging client
 	waitGroup.Add(1)
-	go pingPeriodically(conn, terminateChannel, &waitGroup)
+	go pingPeriodically(conn, &pendingPing, terminateChannel, &waitGroup)
 
-	// associate websocket connection with current user
-	currUser.conn = conn
-
-	// perform handshake (receive userName, validate, register, respond with success if no error)
+	// perform handshake (receive userName, validate, register or resume, respond with success if no error)
 	type handshakeReqPayload struct {
-		Channel  string `json:"channel"`
-		UserName string `json:"userName"`
+		Channel      string `json:"channel"`
+		UserName     string `json:"userName"`
+		SessionToken string `json:"sessionToken"`
+		Secret       string `json:"secret"`   // optional, claims or logs into the persistent profile of userName
+		Protocol     int    `json:"protocol"` // optional, latest binary codec version the client supports
+		Deltas       bool   `json:"deltas"`   // optional, receive delta snapshots and acknowledge them on the ack channel
 	}
 
 	type handshakeResPayload struct {
-		Channel   string `json:"channel"`
-		IsSuccess bool   `json:"isSuccess"`
-		Message   string `json:"message"`
+		Channel      string         `json:"channel"`
+		IsSuccess    bool           `json:"isSuccess"`
+		Message      string         `json:"message"`
+		SessionToken string         `json:"sessionToken,omitempty"`
+		IsResumed    bool           `json:"isResumed,omitempty"`
+		RoomName     string         `json:"roomName,omitempty"`
+		Team         string         `json:"team,omitempty"`
+		Striker      int            `json:"striker,omitempty"`
+		IsHost       bool           `json:"isHost,omitempty"`
+		Ratings      map[string]int `json:"ratings,omitempty"`  // keyed by mode, only set for users with a profile
+		Protocol     int            `json:"protocol,omitempty"` // binary codec version used after the handshake, json if unset
 	}
 
 	var payload handshakeReqPayload
@@ -111,52 +136,175 @@ func createUserHandler(writer http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	currUser.name = payload.UserName
-	err = users.add(&currUser)
-	if err != nil {
-		log.Println("[ERROR]", err)
-		err = conn.WriteJSON(handshakeResPayload{Channel: "handshake", IsSuccess: false, Message: err.Error()})
+	var resPayload handshakeResPayload
+	if payload.SessionToken != "" {
+		// resume session of a user whose slot is being held after they disconnected, or whose old connection has not died yet
+		resumedUser, err := users.resume(payload.UserName, payload.SessionToken, conn)
 		if err != nil {
 			log.Println("[ERROR]", err)
+			err = conn.WriteJSON(handshakeResPayload{Channel: "handshake", IsSuccess: false, Message: err.Error()})
+			if err != nil {
+				log.Println("[ERROR]", err)
+			}
+			return
 		}
-		return
+		currUser = resumedUser
+
+		roomPtr, _ := currUser.getRoom()
+		if roomPtr != nil {
+			roomPtr.resumeSnapshots(currUser, payload.Deltas)
+		} else {
+			currUser.wantsDeltas = payload.Deltas
+		}
+
+		resPayload = handshakeResPayload{Channel: "handshake", IsSuccess: true, Message: fmt.Sprintf("Resumed user %s", currUser.name), SessionToken: currUser.sessionToken, IsResumed: true}
+		if roomPtr != nil {
+			resPayload.RoomName = roomPtr.name
+			resPayload.Team = currUser.team
+			resPayload.Striker = currUser.striker
+			resPayload.IsHost = roomPtr.isHost(currUser)
+		}
+	} else {
+		currUser.name = payload.UserName
+		currUser.wantsDeltas = payload.Deltas
+		currUser.conn = conn
+		currUser.isConnected = true
+		currUser.sessionToken, err = generateToken(sessionTokenLength)
+		if err != nil {
+			log.Println("[ERROR]", err)
+			err = conn.WriteJSON(handshakeResPayload{Channel: "handshake", IsSuccess: false, Message: "internal server error"})
+			if err != nil {
+				log.Println("[ERROR]", err)
+			}
+			return
+		}
+
+		err = users.add(currUser)
+		if err != nil {
+			currUser.name = "" // user was not registered, so there is nothing to cleanup post disconnect
+			log.Println("[ERROR]", err)
+			err = conn.WriteJSON(handshakeResPayload{Channel: "handshake", IsSuccess: false, Message: err.Error()})
+			if err != nil {
+				log.Println("[ERROR]", err)
+			}
+			return
+		}
+
+		// names with a profile can only be used by whoever knows the profile's secret, so the handshake limit of the
+		// name is only taken from once the name is known to be theirs
+		if payload.Secret != "" || profiles.exists(currUser.name) {
+			err = profiles.claim(currUser.name, payload.Secret)
+		}
+		if err == nil {
+			err = rateLimitHandshake(currUser.name)
+		}
+		if err != nil {
+			_ = users.deleteUsingName(currUser.name)
+			currUser.name = ""
+			log.Println("[ERROR]", err)
+			err = conn.WriteJSON(handshakeResPayload{Channel: "handshake", IsSuccess: false, Message: err.Error()})
+			if err != nil {
+				log.Println("[ERROR]", err)
+			}
+			return
+		}
+
+		resPayload = handshakeResPayload{Channel: "handshake", IsSuccess: true, Message: fmt.Sprintf("Created user %s", currUser.name), SessionToken: currUser.sessionToken, Ratings: profiles.ratings(currUser.name)}
 	}
 
-	err = conn.WriteJSON(handshakeResPayload{Channel: "handshake", IsSuccess: true, Message: fmt.Sprintf("Created user %s", currUser.name)})
+	// negotiate the binary codec; the handshake itself is always json
+	if 0 < payload.Protocol {
+		resPayload.Protocol = min(payload.Protocol, codec.Version)
+	}
+
+	err = conn.WriteJSON(resPayload)
 	if err != nil {
 		log.Println("[ERROR]", err)
 		return
 	}
-	log.Printf("[INFO] created user %s\n", currUser.name)
 
-	// start receiving state from user
-	for {
-		if isGloballyMemoryLimited() {
-			log.Printf("[ERROR] globally memory-limited while reading web socket messages of user %s\n", currUser.name)
-			return
+	if resPayload.IsResumed {
+		log.Printf("[INFO] resumed user %s\n", currUser.name)
+		if roomPtr, _ := currUser.getRoom(); roomPtr != nil {
+			roomPtr.broadcastControl(memberConnectivityPayload{Channel: "memberReconnected", UserName: currUser.name})
+			roomPtr.sendChatHistory(currUser)
 		}
+	} else {
+		log.Printf("[INFO] created user %s\n", currUser.name)
+	}
+
+	// start goroutine to write queued messages to client; all writes after the handshake must go through currUser's send queues
+	waitGroup.Add(1)
+	go writeQueued(currUser, conn, resPayload.Protocol, terminateChannel, &waitGroup)
 
-		var newState state
-		err := conn.ReadJSON(&newState)
+	// start receiving messages from user
+	messageLimiter := newMessageRateLimiter()
+	var prevStateErrorTimestamp time.Time
+	for {
+		messageType, data, err := conn.ReadMessage()
 		if err != nil {
 			log.Println("[ERROR] error reading web socket message. Reason:", err)
 			return
 		}
 
-		// log.Println("[INFO] received state:", newState)
+		isAllowed, mustDisconnect := messageLimiter.check()
+		if mustDisconnect {
+			log.Printf("[ERROR] user %s keeps exceeding the message rate limit, disconnecting\n", currUser.name)
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too many messages"), time.Now().Add(webSocketWriteWait*time.Second))
+			return
+		} else if !isAllowed {
+			continue
+		}
 
-		if currUser.room != nil {
-			currUser.room.stateChannel <- &newState
-			// log.Println("[INFO] sent state from user", currUser.name, "to stateChannel of room", currUser.room.name)
+		if isGloballyMemoryLimited() {
+			log.Printf("[ERROR] globally memory-limited while reading web socket messages of user %s\n", currUser.name)
+			return
+		}
+
+		if messageType == websocket.BinaryMessage {
+			data, err = codec.Decode(data)
+		}
+		if err == nil {
+			err = handleMessage(currUser, data)
+		}
+		if err != nil {
+			// a client that sends invalid states sends dozens of them per second, which would fill their control queue with errors
+			if errors.Is(err, errInvalidState) {
+				now := time.Now()
+				if now.Sub(prevStateErrorTimestamp) < stateErrorInterval*time.Second {
+					continue
+				}
+				prevStateErrorTimestamp = now
+			}
+
+			log.Printf("[ERROR] error handling web socket message of user %s. Reason: %v\n", currUser.name, err)
+			err = currUser.sendControl(errorPayload{Channel: "error", Message: err.Error()})
+			if err != nil {
+				log.Println("[ERROR]", err)
+			}
 		}
 	}
 }
 
 type roomPayload struct {
-	RoomName string `json:"roomName"`
-	UserName string `json:"userName"`
-	Team     string `json:"team"`
-	Striker  int    `json:"striker"`
+	RoomName   string `json:"roomName"`
+	UserName   string `json:"userName"`
+	Team       string `json:"team"`
+	Striker    int    `json:"striker"`
+	TickRate   int    `json:"tickRate"`
+	IsPrivate  bool   `json:"isPrivate"`
+	Password   string `json:"password"`
+	InviteCode string `json:"inviteCode"`
+	// match rules
+	GoalLimit   int  `json:"goalLimit"`
+	TimeLimit   int  `json:"timeLimit"`
+	WinByTwo    bool `json:"winByTwo"`
+	SuddenDeath bool `json:"suddenDeath"`
+	// record matches for replay
+	Record bool `json:"record"`
+	// pause settings
+	PauseBudget  *int `json:"pauseBudget"` // nil if unset, as 0 disables pausing
+	PauseTimeout int  `json:"pauseTimeout"`
 }
 
 func createRoomHandler(writer http.ResponseWriter, req *http.Request) {
@@ -186,6 +334,13 @@ func createRoomHandler(writer http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	err = validateRoomPayload(&payload)
+	if err != nil {
+		log.Println("[ERROR]", err)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	_, _, err = rooms.find(payload.RoomName)
 	if err == nil {
 		err := fmt.Errorf("room with name %s already exists", payload.RoomName)
@@ -202,33 +357,22 @@ func createRoomHandler(writer http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	userPtr.team = payload.Team
-	userPtr.striker = payload.Striker
-
-	newRoom := room{
-		name:         payload.RoomName,
-		host:         userPtr,
-		members:      &userArray{slice: make([]*user, 0, maxUsersPerRoom)},
-		stateChannel: make(chan *state),
-	}
-
-	err = newRoom.addMember(userPtr)
+	newRoom, err := createRoom(payload, userPtr)
 	if err != nil {
 		log.Println("[ERROR]", err)
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	matchmaking.dequeue(userPtr)
 
-	err = rooms.add(&newRoom)
-	if err != nil {
-		log.Println("[ERROR]", err)
-		http.Error(writer, err.Error(), http.StatusInternalServerError)
-		return
+	type createRoomResPayload struct {
+		RoomName   string `json:"roomName"`
+		IsPrivate  bool   `json:"isPrivate"`
+		InviteCode string `json:"inviteCode,omitempty"`
 	}
 
-	userPtr.room = &newRoom
-
-	log.Println("[INFO] created room", newRoom.name)
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(createRoomResPayload{RoomName: newRoom.name, IsPrivate: newRoom.isPrivate, InviteCode: newRoom.inviteCode})
 }
 
 func joinRoomHandler(writer http.ResponseWriter, req *http.Request) {
@@ -267,6 +411,20 @@ func joinRoomHandler(writer http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	err = roomPtr.checkSecret(payload.Password, payload.InviteCode)
+	if err != nil {
+		log.Println("[ERROR]", err)
+		http.Error(writer, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	err = roomPtr.checkAdmission(userPtr.name)
+	if err != nil {
+		log.Println("[ERROR]", err)
+		http.Error(writer, err.Error(), http.StatusForbidden)
+		return
+	}
+
 	// verify and assign team to user
 	leftTeamCount, rightTeamCount := roomPtr.getTeamCounts()
 	if payload.Team == "left" && leftTeamCount == maxUsersPerTeam || payload.Team == "right" && rightTeamCount == maxUsersPerTeam {
@@ -303,13 +461,231 @@ func joinRoomHandler(writer http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	userPtr.room = roomPtr
+	// a spectator waiting for a slot stops spectating once they join as a player
+	if spectatedRoomPtr, isSpectator := userPtr.getRoom(); isSpectator {
+		err = spectatedRoomPtr.deleteSpectator(userPtr)
+		if err != nil {
+			log.Println("[ERROR]", err)
+		}
+	}
+
+	userPtr.setRoom(roomPtr, false)
+	matchmaking.dequeue(userPtr)
+	roomPtr.sendChatHistory(userPtr)
 
 	log.Printf("[INFO] user %s joined room %s\n", userPtr.name, roomPtr.name)
 }
 
+type spectatePayload struct {
+	RoomName   string `json:"roomName"`
+	UserName   string `json:"userName"`
+	Password   string `json:"password"`
+	InviteCode string `json:"inviteCode"`
+}
+
+func spectateRoomHandler(writer http.ResponseWriter, req *http.Request) {
+	req.Body = http.MaxBytesReader(writer, req.Body, maxPayloadSize)
+	decoder := json.NewDecoder(req.Body)
+	decoder.DisallowUnknownFields()
+	var payload spectatePayload
+
+	err := decoder.Decode(&payload)
+	if err != nil {
+		log.Println("[ERROR]", err)
+		http.Error(writer, "Something went wrong", http.StatusBadRequest)
+		return
+	}
+
+	if payload.RoomName == "" || payload.UserName == "" {
+		err := errors.New("invalid spectate room request since fields missing in payload")
+		log.Println("[ERROR]", err)
+		http.Error(writer, "Something went wrong", http.StatusBadRequest)
+		return
+	}
+
+	_, roomPtr, err := rooms.find(payload.RoomName)
+	if err != nil {
+		err := errors.New("invalid room name")
+		log.Println("[ERROR]", err)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, userPtr, err := users.find(payload.UserName)
+	if err != nil {
+		err := errors.New("could not find user")
+		log.Println("[ERROR]", err)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if currRoomPtr, _ := userPtr.getRoom(); currRoomPtr != nil {
+		err := errors.New("user is already in a room")
+		log.Println("[ERROR]", err)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = roomPtr.checkSecret(payload.Password, payload.InviteCode)
+	if err != nil {
+		log.Println("[ERROR]", err)
+		http.Error(writer, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	err = roomPtr.checkAdmission(userPtr.name)
+	if err != nil {
+		log.Println("[ERROR]", err)
+		http.Error(writer, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	err = roomPtr.addSpectator(userPtr)
+	if err != nil {
+		log.Println("[ERROR]", err)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userPtr.setRoom(roomPtr, true)
+	matchmaking.dequeue(userPtr)
+	roomPtr.sendChatHistory(userPtr)
+
+	log.Printf("[INFO] user %s is spectating room %s\n", userPtr.name, roomPtr.name)
+}
+
 func listRoomsHandler(writer http.ResponseWriter, req *http.Request) {
 	roomList := rooms.getJoinableRooms()
 	writer.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(writer).Encode(roomList)
 }
+
+func leaderboardHandler(writer http.ResponseWriter, req *http.Request) {
+	mode := req.URL.Query().Get("mode")
+	if mode == "" {
+		mode = "1v1"
+	} else if !slices.Contains(ratedModes, mode) {
+		err := fmt.Errorf("mode must be one of %v", ratedModes)
+		log.Println("[ERROR]", err)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	offset, limit, err := parsePagination(req.URL.Query().Get("offset"), req.URL.Query().Get("limit"))
+	if err != nil {
+		log.Println("[ERROR]", err)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(profiles.leaderboard(mode, offset, limit))
+}
+
+// serves both GET /matches and GET /players/{name}/matches
+func listMatchesHandler(writer http.ResponseWriter, req *http.Request) {
+	offset, limit, err := parsePagination(req.URL.Query().Get("offset"), req.URL.Query().Get("limit"))
+	if err != nil {
+		log.Println("[ERROR]", err)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(history.getMatches(req.PathValue("name"), offset, limit))
+}
+
+// streams a match recording over a web socket as snapshot messages, at real time or at the speed given by the speed query parameter
+func replayHandler(writer http.ResponseWriter, req *http.Request) {
+	speed := 1.0
+	if speedParam := req.URL.Query().Get("speed"); speedParam != "" {
+		var err error
+		speed, err = strconv.ParseFloat(speedParam, 64)
+		if err != nil || speed < minReplaySpeed || maxReplaySpeed < speed {
+			err := fmt.Errorf("speed must be between %v and %v", minReplaySpeed, maxReplaySpeed)
+			log.Println("[ERROR]", err)
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	recReader, err := openRecording(req.PathValue("id"))
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(writer, "recording not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println("[ERROR]", err)
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer recReader.close()
+
+	conn, err := upgrader.Upgrade(writer, req, nil)
+	if err != nil {
+		log.Println("[ERROR] error upgrading to websocket:", err)
+		return
+	}
+	defer conn.Close()
+
+	// guard the connection like the user socket, pinging so that viewers who vanish are dropped within webSocketTimeout seconds
+	conn.SetReadLimit(webSocketReadLimit)
+	conn.SetReadDeadline(time.Now().Add(webSocketTimeout * time.Second))
+	conn.SetPongHandler(func(appData string) error {
+		return conn.SetReadDeadline(time.Now().Add(webSocketTimeout * time.Second))
+	})
+
+	terminateChannel := make(chan struct{})
+	var waitGroup sync.WaitGroup
+	var pendingPing atomic.Int64 // only needed by pingPeriodically(), since viewers' latency isn't measured
+	waitGroup.Add(1)
+	go pingPeriodically(conn, &pendingPing, terminateChannel, &waitGroup)
+	defer func() {
+		close(terminateChannel)
+		waitGroup.Wait()
+	}()
+
+	// the viewer never sends anything, but reading is how a closed connection is noticed
+	closedChannel := make(chan struct{})
+	go func() {
+		defer close(closedChannel)
+		for {
+			_, _, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	log.Printf("[INFO] streaming replay %s at speed %v\n", req.PathValue("id"), speed)
+
+	startTimestamp := time.Now()
+	for {
+		currFrame, err := recReader.next()
+		if err == io.EOF {
+			conn.SetWriteDeadline(time.Now().Add(webSocketWriteWait * time.Second))
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "end of replay"))
+			return
+		} else if err != nil {
+			log.Printf("[ERROR] error reading replay %s. Reason: %v\n", req.PathValue("id"), err)
+			conn.SetWriteDeadline(time.Now().Add(webSocketWriteWait * time.Second))
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, "corrupt recording"))
+			return
+		}
+
+		dueTimestamp := startTimestamp.Add(time.Duration(float64(currFrame.offset) / speed * float64(time.Millisecond)))
+		timer := time.NewTimer(time.Until(dueTimestamp))
+		select {
+		case <-closedChannel:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(webSocketWriteWait * time.Second))
+		err = conn.WriteMessage(websocket.TextMessage, currFrame.data)
+		if err != nil {
+			log.Printf("[ERROR] error streaming replay %s. Reason: %v\n", req.PathValue("id"), err)
+			return
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type matchPlayer struct {
+	Name    string `json:"name"`
+	Team    string `json:"team"`
+	Striker int    `json:"striker"`
+}
+
+type matchRecord struct {
+	ID         int           `json:"id"`
+	RoomName   string        `json:"roomName"`
+	Mode       string        `json:"mode,omitempty"` // "1v1" or "2v2", empty for uneven matches
+	Players    []matchPlayer `json:"players"`
+	LeftScore  int           `json:"leftScore"`
+	RightScore int           `json:"rightScore"`
+	Winner     string        `json:"winner"`
+	Reason     string        `json:"reason"`
+	Duration   int           `json:"duration"`            // measured in seconds, excluding pauses
+	StartTime  int64         `json:"startTime"`           // unix time in milliseconds
+	EndTime    int64         `json:"endTime"`             // unix time in milliseconds
+	ReplayID   string        `json:"replayId,omitempty"`  // pass to GET /replays/{id} to watch the match
+	IsPrivate  bool          `json:"isPrivate,omitempty"` // matches of private rooms are kept, but never listed
+}
+
+// append-only store of finished matches, kept on disk as one json record per line and in memory for querying
+type matchHistory struct {
+	mu      sync.Mutex
+	file    *os.File
+	records []*matchRecord // oldest first
+}
+
+var history matchHistory
+
+type page[T any] struct {
+	Items  []T `json:"items"`
+	Total  int `json:"total"`
+	Offset int `json:"offset"`
+	Limit  int `json:"limit"`
+}
+
+func (history *matchHistory) load(path string) error {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		var record matchRecord
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			// a crash mid-write can leave a truncated last line behind, which shouldn't stop the server from starting
+			log.Printf("[ERROR] skipping unreadable match record on line %d of %s. Reason: %v\n", lineNumber, path, err)
+			continue
+		}
+		history.records = append(history.records, &record)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return err
+	}
+
+	history.file = file
+	log.Printf("[INFO] loaded %d match records from %s\n", len(history.records), path)
+	return nil
+}
+
+func (history *matchHistory) record(record *matchRecord) {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+
+	record.ID = 1
+	if len(history.records) != 0 {
+		record.ID = history.records[len(history.records)-1].ID + 1
+	}
+	history.records = append(history.records, record)
+
+	if history.file == nil {
+		return
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Println("[ERROR] could not encode match record. Reason:", err)
+		return
+	}
+
+	_, err = history.file.Write(append(data, '\n'))
+	if err != nil {
+		log.Println("[ERROR] could not save match record. Reason:", err)
+		return
+	}
+
+	log.Printf("[INFO] recorded match %d of room %s\n", record.ID, record.RoomName)
+}
+
+// returns the public matches that playerName took part in, or all public matches if playerName is empty, newest first
+func (history *matchHistory) getMatches(playerName string, offset int, limit int) page[*matchRecord] {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+
+	matches := make([]*matchRecord, 0)
+	for i := len(history.records) - 1; 0 <= i; i-- {
+		record := history.records[i]
+		if record.IsPrivate {
+			continue
+		}
+		if playerName == "" || slices.ContainsFunc(record.Players, func(player matchPlayer) bool { return player.Name == playerName }) {
+			matches = append(matches, record)
+		}
+	}
+
+	return paginate(matches, offset, limit)
+}
+
+func paginate[T any](items []T, offset int, limit int) page[T] {
+	start := min(offset, len(items))
+	end := start + min(limit, len(items)-start) // offset+limit could overflow
+	return page[T]{Items: items[start:end], Total: len(items), Offset: offset, Limit: limit}
+}
+
+// parses the offset and limit query parameters of a paginated endpoint
+func parsePagination(offsetParam string, limitParam string) (int, int, error) {
+	offset, limit := 0, defaultPageSize
+
+	var err error
+	if offsetParam != "" {
+		offset, err = strconv.Atoi(offsetParam)
+		if err != nil || offset < 0 {
+			return 0, 0, errors.New("offset must be a non-negative integer")
+		}
+	}
+
+	if limitParam != "" {
+		limit, err = strconv.Atoi(limitParam)
+		if err != nil || limit < 1 || maxPageSize < limit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %v", maxPageSize)
+		}
+	}
+
+	return offset, limit, nil
+}
+
+// only call while holding room.mu
+func (room *room) recordMatch(now time.Time, payload matchOverPayload) {
+	record := &matchRecord{
+		RoomName:   room.name,
+		Mode:       room.matchMode(),
+		Players:    make([]matchPlayer, 0, len(room.members.slice)),
+		LeftScore:  payload.LeftScore,
+		RightScore: payload.RightScore,
+		Winner:     payload.Winner,
+		Reason:     payload.Reason,
+		Duration:   payload.Duration,
+		StartTime:  room.matchRealStartTimestamp.UnixMilli(),
+		EndTime:    now.UnixMilli(),
+		IsPrivate:  room.isPrivate,
+	}
+
+	if room.recorder != nil {
+		record.ReplayID = room.recorder.id
+	}
+
+	for _, userPtr := range room.members.slice {
+		record.Players = append(record.Players, matchPlayer{Name: userPtr.name, Team: userPtr.team, Striker: userPtr.striker})
+	}
+
+	// recording writes to disk, which mustn't hold up the room
+	go history.record(record)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestPaginate(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5}
+
+	tests := []struct {
+		name   string
+		offset int
+		limit  int
+		want   []int
+	}{
+		{"first page", 0, 2, []int{1, 2}},
+		{"middle page", 2, 2, []int{3, 4}},
+		{"last page is short", 4, 2, []int{5}},
+		{"offset at the end", 5, 2, []int{}},
+		{"offset beyond the end", 9, 2, []int{}},
+		{"limit beyond the end", 0, 9, []int{1, 2, 3, 4, 5}},
+		{"huge offset", math.MaxInt, 2, []int{}},
+		{"huge limit", 3, math.MaxInt, []int{4, 5}},
+	}
+
+	for _, test := range tests {
+		got := paginate(items, test.offset, test.limit)
+		if !slices.Equal(got.Items, test.want) {
+			t.Errorf("%s: got items %v, want %v", test.name, got.Items, test.want)
+		}
+		if got.Total != len(items) || got.Offset != test.offset || got.Limit != test.limit {
+			t.Errorf("%s: got total %d, offset %d and limit %d, want %d, %d and %d", test.name, got.Total, got.Offset, got.Limit, len(items), test.offset, test.limit)
+		}
+	}
+}
+
+func TestParsePagination(t *testing.T) {
+	tests := []struct {
+		offsetParam string
+		limitParam  string
+		wantOffset  int
+		wantLimit   int
+		wantErr     bool
+	}{
+		{"", "", 0, defaultPageSize, false},
+		{"10", "5", 10, 5, false},
+		{"0", "1", 0, 1, false},
+		{"", strconv.Itoa(maxPageSize), 0, maxPageSize, false},
+		{"", strconv.Itoa(maxPageSize + 1), 0, 0, true},
+		{"-1", "", 0, 0, true},
+		{"ten", "", 0, 0, true},
+		{"", "0", 0, 0, true},
+		{"", "-5", 0, 0, true},
+		{"", "x", 0, 0, true},
+		{"99999999999999999999", "", 0, 0, true},
+	}
+
+	for _, test := range tests {
+		offset, limit, err := parsePagination(test.offsetParam, test.limitParam)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("parsePagination(%q, %q) succeeded, want error", test.offsetParam, test.limitParam)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("parsePagination(%q, %q): %v", test.offsetParam, test.limitParam, err)
+		} else if offset != test.wantOffset || limit != test.wantLimit {
+			t.Errorf("parsePagination(%q, %q) = %d, %d, want %d, %d", test.offsetParam, test.limitParam, offset, limit, test.wantOffset, test.wantLimit)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// clients see the puck late by about half their round trip, so a striker that touches the puck on their screen may
+// miss it on the server; a client that sees a hit reports it, and the server rewinds the puck to the time the client
+// perceived and the striker to the state the client sent, then replays the collision if they touched within tolerance
+// and catches the puck up to the present against the strikers of the states received meanwhile
+
+type puckFrame struct {
+	tick      uint64
+	timestamp time.Time
+	puck      puck
+}
+
+type strikerFrame struct {
+	seq       uint64
+	timestamp time.Time
+	striker   striker
+}
+
+type hitResPayload struct {
+	Channel    string `json:"channel"`
+	Seq        uint64 `json:"seq"`
+	IsAccepted bool   `json:"isAccepted"`
+	Message    string `json:"message,omitempty"`
+}
+
+// only call while holding room.mu; remembers where the puck was after the physics step at now
+func (room *room) recordPuckFrame(now time.Time) {
+	room.physicsTick++
+	room.puckFrames[room.physicsTick%puckHistorySize] = puckFrame{tick: room.physicsTick, timestamp: now, puck: room.puck}
+}
+
+// only call while holding room.mu; frames from before the puck was reset can't be rewound to
+func (room *room) forgetPuckFrames() {
+	room.puckFrames = [puckHistorySize]puckFrame{}
+}
+
+// only call while holding room.mu; returns the stored frame closest to timestamp
+func (room *room) puckFrameAt(timestamp time.Time) (puckFrame, bool) {
+	var closestFrame puckFrame
+	isFound := false
+	for _, frame := range room.puckFrames {
+		if frame.tick == 0 {
+			continue
+		}
+		if !isFound || frame.timestamp.Sub(timestamp).Abs() < closestFrame.timestamp.Sub(timestamp).Abs() {
+			closestFrame = frame
+			isFound = true
+		}
+	}
+
+	return closestFrame, isFound && closestFrame.timestamp.Sub(timestamp).Abs() <= time.Second/physicsTickRate
+}
+
+// only call while holding room.mu; remembers where userPtr's striker was according to the state they sent
+func (room *room) recordStrikerFrame(userPtr *user, currStatePtr *state) {
+	userPtr.strikerFrames[currStatePtr.Seq%strikerHistorySize] = strikerFrame{
+		seq:       currStatePtr.Seq,
+		timestamp: time.Now(),
+		striker: striker{
+			xPos: fromSignificantDigits(currStatePtr.PlayerXPos, boardWidth),
+			yPos: fromSignificantDigits(currStatePtr.PlayerYPos, boardHeight),
+			xVel: fromSignificantDigits(currStatePtr.PlayerXVel, boardWidth),
+			yVel: fromSignificantDigits(currStatePtr.PlayerYVel, boardHeight),
+		},
+	}
+}
+
+// only call while holding room.mu; returns the striker of the latest state of userPtr received by timestamp
+func (room *room) strikerFrameAt(userPtr *user, timestamp time.Time) (strikerFrame, bool) {
+	var latestFrame strikerFrame
+	isFound := false
+	for _, frame := range userPtr.strikerFrames {
+		if frame.seq == 0 || timestamp.Before(frame.timestamp) {
+			continue
+		}
+		if !isFound || latestFrame.timestamp.Before(frame.timestamp) {
+			latestFrame = frame
+			isFound = true
+		}
+	}
+
+	return latestFrame, isFound
+}
+
+// decides whether the hit userPtr saw between the striker of their state seq and the puck at perceivedTime was valid, and replays it if so
+func (room *room) arbitrateHit(userPtr *user, seq uint64, perceivedTime time.Time) hitResPayload {
+	resPayload := hitResPayload{Channel: "hit", Seq: seq}
+	latency, ok := userPtr.getLatency()
+	if !ok {
+		resPayload.Message = "latency has not been measured yet"
+		return resPayload
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	err := room.replayHit(userPtr, seq, perceivedTime, latency, time.Now())
+	if err != nil {
+		resPayload.Message = err.Error()
+		return resPayload
+	}
+
+	resPayload.IsAccepted = true
+	return resPayload
+}
+
+// only call while holding room.mu
+func (room *room) replayHit(userPtr *user, seq uint64, perceivedTime time.Time, latency latencyPayload, now time.Time) error {
+	if room.phase != "playing" || room.isGoal {
+		return errors.New("puck is not in play")
+	} else if _, memberPtr, err := room.members.find(userPtr.name); err != nil || memberPtr != userPtr {
+		return errors.New("only players can hit the puck")
+	}
+
+	// a client clock that runs ahead of the server's can't see the future, and a client sees the puck about a round trip
+	// before its report arrives, so it can't claim to have seen it any earlier to get a longer rewind
+	rtt := time.Duration(latency.RTT) * time.Millisecond
+	tolerance := time.Second/physicsTickRate + hitJitterFactor*time.Duration(latency.Jitter)*time.Millisecond
+	if now.Before(perceivedTime) {
+		perceivedTime = now
+	} else if rtt+tolerance < now.Sub(perceivedTime) {
+		perceivedTime = now.Add(-rtt - tolerance)
+	}
+	if maxRewindDuration*time.Millisecond < now.Sub(perceivedTime) {
+		return errors.New("hit is too old to be compensated")
+	}
+
+	strikerPtr, ok := room.strikers[userPtr.name]
+	if !ok {
+		return errors.New("striker is not on the board")
+	} else if now.Sub(strikerPtr.prevCollisionTimestamp) < puckPlayerCollisionCooldown*time.Millisecond {
+		return nil // the server saw the hit too
+	}
+
+	// in 2v2 the first hit the server knows about wins, a late report can't undo what happened since
+	if perceivedTime.Before(room.puckHitTimestamp) {
+		return errors.New("puck was hit again since")
+	}
+
+	hitFrame := userPtr.strikerFrames[seq%strikerHistorySize]
+	if hitFrame.seq != seq || seq == 0 || maxRewindDuration*time.Millisecond < now.Sub(hitFrame.timestamp) {
+		return errors.New("state of the hit is unknown")
+	}
+
+	// the state left the client when it saw the puck at perceivedTime and arrived half a round trip later, while the
+	// puck on its screen was half a round trip old; a striker from any other time didn't touch that puck
+	if tolerance < hitFrame.timestamp.Sub(perceivedTime.Add(rtt)).Abs() {
+		return errors.New("state was not sent when the puck was seen")
+	}
+
+	rewoundFrame, ok := room.puckFrameAt(perceivedTime)
+	if !ok {
+		return errors.New("puck can't be rewound that far")
+	}
+
+	dx := rewoundFrame.puck.xPos - hitFrame.striker.xPos
+	dy := rewoundFrame.puck.yPos - hitFrame.striker.yPos
+	distance := math.Sqrt(dx*dx + dy*dy)
+	radiiSum := (puckRadiusFraction + playerRadiusFraction) * boardWidth
+	if distance == 0 || radiiSum+hitTolerance < distance {
+		return errors.New("striker did not reach the puck")
+	}
+
+	// collide with the rewound puck, then catch up to the present the way the physics steps would have
+	room.puck = rewoundFrame.puck
+	room.bouncePuck(&hitFrame.striker)
+
+	collisionTimestamps := map[string]time.Time{userPtr.name: rewoundFrame.timestamp}
+	for tick := rewoundFrame.tick + 1; tick <= room.physicsTick; tick++ {
+		room.movePuck()
+		if room.handlePuckBoardCollisions(now) {
+			break
+		}
+		room.replayStrikerCollisions(room.puckFrames[tick%puckHistorySize].timestamp, collisionTimestamps)
+	}
+
+	for userName := range collisionTimestamps {
+		if collidedStrikerPtr, ok := room.strikers[userName]; ok {
+			collidedStrikerPtr.prevCollisionTimestamp = now
+		}
+	}
+	room.puckHitTimestamp = now
+	return nil
+}
+
+// only call while holding room.mu; like handlePuckStrikerCollisions() during the physics step at timestamp, but with
+// each member's striker as of the states received by then and the cooldowns of the replay in collisionTimestamps
+func (room *room) replayStrikerCollisions(timestamp time.Time, collisionTimestamps map[string]time.Time) {
+	for _, userPtr := range room.members.slice {
+		frame, ok := room.strikerFrameAt(userPtr, timestamp)
+		if !ok {
+			continue
+		}
+
+		dx := room.puck.xPos - frame.striker.xPos
+		dy := room.puck.yPos - frame.striker.yPos
+		distance := math.Sqrt(dx*dx + dy*dy)
+		radiiSum := (puckRadiusFraction + playerRadiusFraction) * boardWidth
+		isColliding := distance <= radiiSum && distance != 0
+
+		prevCollisionTimestamp, ok := collisionTimestamps[userPtr.name]
+		mustSkipCollision := ok && timestamp.Sub(prevCollisionTimestamp) < puckPlayerCollisionCooldown*time.Millisecond
+
+		if !isColliding || mustSkipCollision {
+			continue
+		}
+
+		collisionTimestamps[userPtr.name] = timestamp
+		room.bouncePuck(&frame.striker)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+const testTick = time.Second / physicsTickRate
+
+// fills room's puck history with frames up to now, the puck being where puckAt says it was at each frame's time
+func recordTestPuckFrames(room *room, now time.Time, puckAt func(timestamp time.Time) puck) {
+	for i := puckHistorySize - 1; 0 <= i; i-- {
+		timestamp := now.Add(-time.Duration(i) * testTick)
+		room.puck = puckAt(timestamp)
+		room.recordPuckFrame(timestamp)
+	}
+}
+
+func TestReplayHit(t *testing.T) {
+	radiiSum := (puckRadiusFraction + playerRadiusFraction) * boardWidth
+	latency := latencyPayload{RTT: 60, Jitter: 2}
+	rtt := time.Duration(latency.RTT) * time.Millisecond
+	stillPuck := func(time.Time) puck { return puck{xPos: 800, yPos: 450} }
+
+	tests := []struct {
+		name        string
+		phase       string
+		puckAt      func(now time.Time) func(timestamp time.Time) puck
+		strikerXPos float64
+		perceivedAt time.Duration // before now
+		receivedAt  time.Duration // before now
+		wantErr     string
+	}{
+		{"hit", "playing", nil, 800 - radiiSum + 1, rtt, 5 * time.Millisecond, ""},
+		{"puck not in play", "lobby", nil, 800 - radiiSum + 1, rtt, 5 * time.Millisecond, "puck is not in play"},
+		{"striker too far", "playing", nil, 600, rtt, 5 * time.Millisecond, "striker did not reach the puck"},
+		{"state sent before the puck was seen", "playing", nil, 800 - radiiSum + 1, rtt, rtt, "state was not sent when the puck was seen"},
+		{"state of the future", "playing", nil, 800 - radiiSum + 1, rtt, -rtt, "state was not sent when the puck was seen"},
+		{
+			// the puck only touched the striker before the client could have seen it
+			"perceived time beyond the round trip", "playing",
+			func(now time.Time) func(timestamp time.Time) puck {
+				return func(timestamp time.Time) puck {
+					if timestamp.Before(now.Add(-150 * time.Millisecond)) {
+						return puck{xPos: 800, yPos: 450}
+					}
+					return puck{xPos: 1200, yPos: 450}
+				}
+			},
+			800 - radiiSum + 1, 190 * time.Millisecond, 5 * time.Millisecond, "striker did not reach the puck",
+		},
+	}
+
+	for _, test := range tests {
+		now := time.Now()
+		room := newTestRoom(now)
+		room.phase = test.phase
+		puckAt := stillPuck
+		if test.puckAt != nil {
+			puckAt = test.puckAt(now)
+		}
+		recordTestPuckFrames(room, now, puckAt)
+
+		hitterPtr := room.members.slice[0]
+		room.strikers[hitterPtr.name] = &striker{}
+		hitterPtr.strikerFrames[7%strikerHistorySize] = strikerFrame{seq: 7, timestamp: now.Add(-test.receivedAt), striker: striker{xPos: test.strikerXPos, yPos: 450, xVel: 10}}
+
+		err := room.replayHit(hitterPtr, 7, now.Add(-test.perceivedAt), latency, now)
+		if test.wantErr != "" {
+			if err == nil || err.Error() != test.wantErr {
+				t.Errorf("%s: got error %v, want %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if room.puck.xVel <= 0 {
+			t.Errorf("%s: got puck velocity %v, want the puck to move away from the striker", test.name, room.puck.xVel)
+		} else if !room.puckHitTimestamp.Equal(now) || !room.strikers[hitterPtr.name].prevCollisionTimestamp.Equal(now) {
+			t.Errorf("%s: hit wasn't recorded at now", test.name)
+		}
+	}
+}
+
+func TestReplayHitCollidesWithRecordedStrikers(t *testing.T) {
+	radiiSum := (puckRadiusFraction + playerRadiusFraction) * boardWidth
+	now := time.Now()
+	room := newTestRoom(now)
+	recordTestPuckFrames(room, now, func(time.Time) puck { return puck{xPos: 800, yPos: 450} })
+
+	hitterPtr, blockerPtr := room.members.slice[0], room.members.slice[1]
+	room.strikers[hitterPtr.name] = &striker{}
+	room.strikers[blockerPtr.name] = &striker{}
+	hitterPtr.strikerFrames[7%strikerHistorySize] = strikerFrame{seq: 7, timestamp: now, striker: striker{xPos: 800 - radiiSum + 1, yPos: 450, xVel: 10}}
+
+	// the blocker stood right in the path the hit sends the puck along
+	blockerPtr.strikerFrames[3%strikerHistorySize] = strikerFrame{seq: 3, timestamp: now.Add(-time.Second), striker: striker{xPos: 801 + radiiSum + 30, yPos: 450}}
+
+	err := room.replayHit(hitterPtr, 7, now.Add(-60*time.Millisecond), latencyPayload{RTT: 60, Jitter: 2}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if 0 <= room.puck.xVel {
+		t.Fatalf("got puck velocity %v, want the puck to bounce back off the blocker", room.puck.xVel)
+	} else if !room.strikers[blockerPtr.name].prevCollisionTimestamp.Equal(now) {
+		t.Fatal("blocker's collision wasn't recorded")
+	}
+}
+
+func TestReplayHitLosesToLaterServerHit(t *testing.T) {
+	radiiSum := (puckRadiusFraction + playerRadiusFraction) * boardWidth
+	now := time.Now()
+	room := newTestRoom(now)
+	recordTestPuckFrames(room, now, func(time.Time) puck { return puck{xPos: 800, yPos: 450} })
+	room.puckHitTimestamp = now.Add(-20 * time.Millisecond)
+
+	hitterPtr := room.members.slice[0]
+	room.strikers[hitterPtr.name] = &striker{}
+	hitterPtr.strikerFrames[7%strikerHistorySize] = strikerFrame{seq: 7, timestamp: now, striker: striker{xPos: 800 - radiiSum + 1, yPos: 450, xVel: 10}}
+
+	err := room.replayHit(hitterPtr, 7, now.Add(-60*time.Millisecond), latencyPayload{RTT: 60, Jitter: 2}, now)
+	if err == nil || err.Error() != "puck was hit again since" {
+		t.Fatalf("got error %v, want the later hit to win", err)
+	}
+}
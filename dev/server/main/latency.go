@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// round-trip time of a user's connection, smoothed the way tcp does it (rfc 6298)
+type latencyStats struct {
+	rtt         time.Duration // smoothed round-trip time
+	jitter      time.Duration // smoothed deviation of samples from rtt
+	sampleCount int
+}
+
+type latencyPayload struct {
+	RTT    int64 `json:"rtt"`    // measured in milliseconds
+	Jitter int64 `json:"jitter"` // measured in milliseconds
+}
+
+type timeSyncPayload struct {
+	Channel    string  `json:"channel"`
+	ClientTime float64 `json:"clientTime"` // echoed back, so the client can measure the round trip with its own clock
+	ServerTime int64   `json:"serverTime"` // unix time in milliseconds at which the request was answered
+	RTT        int64   `json:"rtt,omitempty"`
+	Jitter     int64   `json:"jitter,omitempty"`
+}
+
+// ping payloads are offsets from serverStartTimestamp, which keeps round trips on the monotonic clock
+var serverStartTimestamp = time.Now()
+
+// returns the payload of a ping sent now, after remembering it as the one pong to wait for
+func nextPingPayload(pendingPing *atomic.Int64) []byte {
+	sentOffset := int64(time.Since(serverStartTimestamp))
+	pendingPing.Store(sentOffset)
+	return strconv.AppendInt(nil, sentOffset, 10)
+}
+
+// records the round trip of appData's ping; unsolicited and repeated pongs are ignored, so clients can't skew the stats
+func (user *user) recordPong(appData string, pendingPing *atomic.Int64) {
+	sentOffset, err := strconv.ParseInt(appData, 10, 64)
+	if err != nil || sentOffset == 0 || !pendingPing.CompareAndSwap(sentOffset, 0) {
+		return
+	}
+
+	sample := time.Since(serverStartTimestamp) - time.Duration(sentOffset)
+
+	user.mu.Lock()
+	user.latency.add(sample)
+	user.mu.Unlock()
+}
+
+// returns false if no pong has been received yet
+func (user *user) getLatency() (latencyPayload, bool) {
+	user.mu.Lock()
+	defer user.mu.Unlock()
+
+	if user.latency.sampleCount == 0 {
+		return latencyPayload{}, false
+	}
+
+	return latencyPayload{RTT: user.latency.rtt.Milliseconds(), Jitter: user.latency.jitter.Milliseconds()}, true
+}
+
+func (stats *latencyStats) add(sample time.Duration) {
+	if stats.sampleCount == 0 {
+		stats.rtt = sample
+		stats.jitter = sample / 2
+	} else {
+		stats.jitter += ((stats.rtt - sample).Abs() - stats.jitter) / 4
+		stats.rtt += (sample - stats.rtt) / 8
+	}
+	stats.sampleCount++
+}
+
+// answers a time sync request right away; the server time is read as late as possible to keep the estimate tight
+func (user *user) syncTime(clientTime float64) error {
+	payload := timeSyncPayload{Channel: "timeSync", ClientTime: clientTime}
+	if latency, ok := user.getLatency(); ok {
+		payload.RTT = latency.RTT
+		payload.Jitter = latency.Jitter
+	}
+	payload.ServerTime = time.Now().UnixMilli()
+
+	return user.sendControl(payload)
+}
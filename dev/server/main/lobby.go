@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+type readyPayload struct {
+	Channel  string `json:"channel"`
+	UserName string `json:"userName"`
+	IsReady  bool   `json:"isReady"`
+	AllReady bool   `json:"allReady"`
+}
+
+type countdownPayload struct {
+	Channel     string `json:"channel"`
+	Reason      string `json:"reason"`      // "matchStart", "goal", "stuckPuck" or "resume"
+	ServerTime  int64  `json:"serverTime"`  // unix time in milliseconds at which the countdown started
+	KickoffTime int64  `json:"kickoffTime"` // unix time in milliseconds at which the puck is released
+}
+
+func (room *room) setReady(userPtr *user, isReady bool) error {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if room.phase != "lobby" {
+		return errors.New("match is already underway")
+	}
+
+	if room.readiness == nil {
+		room.readiness = make(map[string]bool, maxUsersPerRoom)
+	}
+	room.readiness[userPtr.name] = isReady
+
+	room.sendControlToAudience(readyPayload{Channel: "ready", UserName: userPtr.name, IsReady: isReady, AllReady: room.isEveryoneReady()})
+	return nil
+}
+
+func (room *room) startMatch(userPtr *user) error {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if room.host != userPtr {
+		return errors.New("only the host can start the match")
+	} else if room.phase != "lobby" {
+		return errors.New("match is already underway")
+	} else if len(room.members.slice) <= 1 {
+		return errors.New("waiting for other players to join")
+	} else if !room.isEveryoneReady() {
+		return errors.New("not everyone is ready")
+	}
+
+	room.leftScore = 0
+	room.rightScore = 0
+	room.matchStartTimestamp = time.Time{}
+	room.matchRealStartTimestamp = time.Time{}
+	room.isSuddenDeath = false
+	room.isMatchOver = false
+	clear(room.pausesUsed)
+	room.resetRound()
+
+	now := time.Now()
+	room.startRecording(now)
+	room.startCountdown(now, "matchStart")
+
+	log.Printf("[INFO] user %s started a match in room %s\n", userPtr.name, room.name)
+	return nil
+}
+
+// only call while holding room.mu
+func (room *room) isEveryoneReady() bool {
+	if len(room.members.slice) <= 1 {
+		return false
+	}
+
+	for _, userPtr := range room.members.slice {
+		if !userPtr.isBot && !room.readiness[userPtr.name] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// only call while holding room.mu; every kickoff goes through a countdown so that all clients release the puck at the same moment
+func (room *room) startCountdown(now time.Time, reason string) {
+	duration := kickoffCountdownDuration * time.Millisecond
+	if reason == "matchStart" {
+		duration = matchCountdownDuration * time.Millisecond
+	}
+
+	room.phase = "countdown"
+	room.kickoffTimestamp = now.Add(duration)
+
+	room.sendControlToAudience(countdownPayload{Channel: "countdown", Reason: reason, ServerTime: now.UnixMilli(), KickoffTime: room.kickoffTimestamp.UnixMilli()})
+}
+
+// only call while holding room.mu; readiness is kept, so members who are still there needn't confirm again when a
+// match is cut short, e.g. because everyone else left
+func (room *room) returnToLobby() {
+	room.phase = "lobby"
+	room.resetRound()
+	room.stopRecording()
+}
+
+type memberSwitchedPayload struct {
+	Channel  string `json:"channel"`
+	UserName string `json:"userName"`
+	Team     string `json:"team"`
+	Striker  int    `json:"striker"`
+}
+
+// moves userPtr to another team and/or striker between matches, using the same rules as joining a room
+func (room *room) switchSides(userPtr *user, team string, striker int) error {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if room.phase != "lobby" {
+		return errors.New("can only switch team or striker between matches")
+	}
+
+	if team != "left" && team != "right" {
+		return errors.New("team must be left or right")
+	} else if team != userPtr.team && (team == "left" && room.leftTeamCount == maxUsersPerTeam || team == "right" && room.rightTeamCount == maxUsersPerTeam) {
+		return fmt.Errorf("%s team is full", team)
+	}
+
+	if striker < 0 || maxUsersPerRoom <= striker {
+		return fmt.Errorf("striker must be between 0 and %v", maxUsersPerRoom-1)
+	}
+	for _, memberPtr := range room.members.slice {
+		if memberPtr != userPtr && memberPtr.striker == striker {
+			return errors.New("striker is taken")
+		}
+	}
+
+	if team != userPtr.team {
+		if team == "left" {
+			room.leftTeamCount++
+			room.rightTeamCount--
+		} else {
+			room.rightTeamCount++
+			room.leftTeamCount--
+		}
+	}
+	userPtr.team = team
+	userPtr.striker = striker
+
+	// the stale state would otherwise show userPtr on their previous side until they send a new one
+	delete(room.latestStates, userPtr.name)
+
+	room.sendControlToAudience(memberSwitchedPayload{Channel: "memberSwitched", UserName: userPtr.name, Team: team, Striker: striker})
+
+	log.Printf("[INFO] user %s switched to striker %d of %s team in room %s\n", userPtr.name, striker, team, room.name)
+	return nil
+}
@@ -24,6 +24,33 @@ func main() {
 	log.SetOutput(file)
 	go rotateLogs(logFile)
 
+	// load persistent player profiles
+	err = profiles.load(filepath.Join(serverDir, "profiles.json"))
+	if err != nil {
+		log.Fatalln("[ERROR] failed to load profiles. Reason:", err)
+	}
+
+	// load history of finished matches
+	err = history.load(filepath.Join(serverDir, "matches.jsonl"))
+	if err != nil {
+		log.Fatalln("[ERROR] failed to load match history. Reason:", err)
+	}
+
+	// store match recordings next to the server
+	recordingsDir = filepath.Join(serverDir, "recordings")
+	err = os.MkdirAll(recordingsDir, 0755)
+	if err != nil {
+		log.Fatalln("[ERROR] failed to create recordings directory. Reason:", err)
+	}
+	go pruneRecordingsPeriodically()
+
+	// limit requests per client behind an optional reverse proxy
+	trustedProxyHeader = os.Getenv("TRUSTED_PROXY_HEADER")
+	if trustedProxyHeader != "" {
+		log.Printf("[INFO] reading client ips from header %s\n", trustedProxyHeader)
+	}
+	go evictIdleRateLimitersPeriodically()
+
 	// test setup
 	// createTestRooms()
 
@@ -40,6 +67,11 @@ func main() {
 	http.HandleFunc("GET /rooms", middlewareChain(listRoomsHandler))
 	http.HandleFunc("POST /room", middlewareChain(createRoomHandler))
 	http.HandleFunc("POST /join", middlewareChain(joinRoomHandler))
+	http.HandleFunc("POST /spectate", middlewareChain(spectateRoomHandler))
+	http.HandleFunc("GET /leaderboard", middlewareChain(leaderboardHandler))
+	http.HandleFunc("GET /matches", middlewareChain(listMatchesHandler))
+	http.HandleFunc("GET /players/{name}/matches", middlewareChain(listMatchesHandler))
+	http.HandleFunc("GET /replays/{id}", middlewareChain(replayHandler))
 
 	// serve
 	port := os.Getenv("PORT")
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+type matchRules struct {
+	goalLimit   int  // 0 means no goal limit
+	timeLimit   int  // measured in seconds, 0 means no time limit
+	winByTwo    bool // goal limit only ends the match once a team leads by at least two goals
+	suddenDeath bool // if scores are level when time runs out, the next goal wins instead of the match ending in a draw
+}
+
+type matchOverPayload struct {
+	Channel    string `json:"channel"`
+	Winner     string `json:"winner"` // "left", "right" or "draw"
+	Reason     string `json:"reason"` // "goalLimit", "timeLimit" or "suddenDeath"
+	LeftScore  int    `json:"leftScore"`
+	RightScore int    `json:"rightScore"`
+	Duration   int    `json:"duration"`           // measured in seconds
+	ReplayID   string `json:"replayId,omitempty"` // only shared with the room, as recordings of private rooms aren't listed
+}
+
+func validateMatchRules(rules matchRules) error {
+	if rules.goalLimit < 0 || maxGoalLimit < rules.goalLimit {
+		return fmt.Errorf("goal limit must be between 0 and %v", maxGoalLimit)
+	}
+
+	if rules.timeLimit < 0 || maxTimeLimit < rules.timeLimit {
+		return fmt.Errorf("time limit must be between 0 and %v seconds", maxTimeLimit)
+	}
+
+	return nil
+}
+
+// only call while holding room.mu; ends the match and returns true if room's rules say the match is over
+func (room *room) checkMatchOver(now time.Time) bool {
+	if room.isMatchOver {
+		return true
+	}
+
+	scoreDiff := room.leftScore - room.rightScore
+	if scoreDiff < 0 {
+		scoreDiff = -scoreDiff
+	}
+
+	if room.rules.goalLimit != 0 && room.rules.goalLimit <= max(room.leftScore, room.rightScore) && (!room.rules.winByTwo || 2 <= scoreDiff) {
+		room.endMatch(now, "goalLimit")
+		return true
+	}
+
+	isTimeUp := room.rules.timeLimit != 0 && time.Duration(room.rules.timeLimit)*time.Second <= now.Sub(room.matchStartTimestamp)
+	if !isTimeUp {
+		return false
+	}
+
+	if scoreDiff == 0 && room.rules.suddenDeath {
+		// play on until the next goal
+		if !room.isSuddenDeath {
+			room.isSuddenDeath = true
+			log.Printf("[INFO] match in room %s went into sudden death\n", room.name)
+		}
+		return false
+	}
+
+	if room.isSuddenDeath {
+		room.endMatch(now, "suddenDeath")
+	} else {
+		room.endMatch(now, "timeLimit")
+	}
+	return true
+}
+
+// only call while holding room.mu
+func (room *room) endMatch(now time.Time, reason string) {
+	room.isMatchOver = true
+
+	winner := "draw"
+	if room.rightScore < room.leftScore {
+		winner = "left"
+	} else if room.leftScore < room.rightScore {
+		winner = "right"
+	}
+
+	payload := matchOverPayload{
+		Channel:    "matchOver",
+		Winner:     winner,
+		Reason:     reason,
+		LeftScore:  room.leftScore,
+		RightScore: room.rightScore,
+		Duration:   int(now.Sub(room.matchStartTimestamp).Seconds()),
+	}
+	if room.recorder != nil {
+		payload.ReplayID = room.recorder.id
+	}
+	room.sendControlToAudience(payload)
+	room.recordMatch(now, payload)
+	room.rateMatch(winner)
+	clear(room.readiness) // everyone confirms the rematch, clients send ready again on matchOver
+	room.returnToLobby()
+
+	log.Printf("[INFO] match in room %s is over (%s), winner is %s with score %d-%d\n", room.name, reason, winner, room.leftScore, room.rightScore)
+}
+
+// only call while holding room.mu; only evenly matched 1v1 and 2v2 matches between humans are rated
+func (room *room) rateMatch(winner string) {
+	mode := room.matchMode()
+	if mode == "" {
+		return
+	}
+
+	// matches against bots don't count towards ratings
+	for _, userPtr := range room.members.slice {
+		if userPtr.isBot {
+			return
+		}
+	}
+
+	var leftNames, rightNames []string
+	for _, userPtr := range room.members.slice {
+		if userPtr.team == "left" {
+			leftNames = append(leftNames, userPtr.name)
+		} else {
+			rightNames = append(rightNames, userPtr.name)
+		}
+	}
+
+	// rating saves profiles to disk, which mustn't hold up the room
+	go profiles.recordMatch(mode, leftNames, rightNames, winner)
+}
+
+// only call while holding room.mu; returns "1v1" or "2v2" if both teams are evenly manned, otherwise an empty string
+func (room *room) matchMode() string {
+	if room.leftTeamCount != room.rightTeamCount || room.leftTeamCount == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%dv%d", room.leftTeamCount, room.rightTeamCount)
+}
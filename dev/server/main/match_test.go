@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// returns the matchOver payload sent to userPtr, if any
+func receivedMatchOver(t *testing.T, userPtr *user) (matchOverPayload, bool) {
+	t.Helper()
+
+	for {
+		select {
+		case data := <-userPtr.controlQueue:
+			var payload matchOverPayload
+			err := json.Unmarshal(data, &payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if payload.Channel == "matchOver" {
+				return payload, true
+			}
+		default:
+			return matchOverPayload{}, false
+		}
+	}
+}
+
+func TestCheckMatchOver(t *testing.T) {
+	tests := []struct {
+		name       string
+		rules      matchRules
+		leftScore  int
+		rightScore int
+		elapsed    time.Duration
+		wantOver   bool
+		wantWinner string
+		wantReason string
+	}{
+		{"no rules", matchRules{}, 9, 0, time.Hour, false, "", ""},
+		{"below goal limit", matchRules{goalLimit: 5}, 4, 3, time.Minute, false, "", ""},
+		{"goal limit", matchRules{goalLimit: 5}, 5, 3, time.Minute, true, "left", "goalLimit"},
+		{"goal limit without a two goal lead", matchRules{goalLimit: 5, winByTwo: true}, 4, 5, time.Minute, false, "", ""},
+		{"goal limit with a two goal lead", matchRules{goalLimit: 5, winByTwo: true}, 4, 6, time.Minute, true, "right", "goalLimit"},
+		{"time left", matchRules{timeLimit: 60}, 1, 0, 59 * time.Second, false, "", ""},
+		{"time up", matchRules{timeLimit: 60}, 1, 0, time.Minute, true, "left", "timeLimit"},
+		{"time up in a draw", matchRules{timeLimit: 60}, 2, 2, time.Minute, true, "draw", "timeLimit"},
+		{"time up in a draw with sudden death", matchRules{timeLimit: 60, suddenDeath: true}, 2, 2, time.Minute, false, "", ""},
+	}
+
+	for _, test := range tests {
+		now := time.Now()
+		room := newTestRoom(now.Add(-test.elapsed))
+		room.rules = test.rules
+		room.leftScore = test.leftScore
+		room.rightScore = test.rightScore
+
+		if got := room.checkMatchOver(now); got != test.wantOver {
+			t.Errorf("%s: got match over %v, want %v", test.name, got, test.wantOver)
+			continue
+		}
+
+		payload, ok := receivedMatchOver(t, room.members.slice[0])
+		if ok != test.wantOver {
+			t.Errorf("%s: got matchOver sent %v, want %v", test.name, ok, test.wantOver)
+		} else if ok && (payload.Winner != test.wantWinner || payload.Reason != test.wantReason) {
+			t.Errorf("%s: got winner %q for %q, want %q for %q", test.name, payload.Winner, payload.Reason, test.wantWinner, test.wantReason)
+		} else if ok && room.phase != "lobby" {
+			t.Errorf("%s: got phase %q after the match, want lobby", test.name, room.phase)
+		}
+	}
+}
+
+func TestCheckMatchOverSuddenDeath(t *testing.T) {
+	now := time.Now()
+	room := newTestRoom(now.Add(-time.Minute))
+	room.rules = matchRules{timeLimit: 60, suddenDeath: true}
+	room.leftScore, room.rightScore = 1, 1
+
+	if room.checkMatchOver(now) || !room.isSuddenDeath {
+		t.Fatal("level match didn't go into sudden death when time ran out")
+	}
+
+	now = now.Add(10 * time.Second)
+	room.rightScore++
+	if !room.checkMatchOver(now) {
+		t.Fatal("goal in sudden death didn't end the match")
+	}
+
+	payload, ok := receivedMatchOver(t, room.members.slice[1])
+	if !ok || payload.Winner != "right" || payload.Reason != "suddenDeath" || payload.Duration != 70 {
+		t.Fatalf("got %+v, want right winning by sudden death after 70 seconds", payload)
+	}
+
+	// a match only ends once
+	if !room.checkMatchOver(now) {
+		t.Fatal("ended match is no longer over")
+	} else if _, ok := receivedMatchOver(t, room.members.slice[1]); ok {
+		t.Fatal("ended match was announced twice")
+	}
+}
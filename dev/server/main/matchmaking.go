@@ -0,0 +1,325 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+type queuedUser struct {
+	userPtr   *user
+	mode      string
+	timestamp time.Time
+}
+
+type matchmaker struct {
+	mu     sync.Mutex
+	queues map[string][]*queuedUser // keyed by mode, oldest first
+}
+
+var matchmaking = matchmaker{queues: map[string][]*queuedUser{"1v1": {}, "2v2": {}}}
+
+type queuedPayload struct {
+	Channel string `json:"channel"`
+	Mode    string `json:"mode"`
+	Timeout int    `json:"timeout"` // measured in seconds
+}
+
+type queueLeftPayload struct {
+	Channel string `json:"channel"` // "queueCancelled" or "queueTimeout"
+	Mode    string `json:"mode"`
+}
+
+type matchFoundPayload struct {
+	Channel    string         `json:"channel"`
+	Mode       string         `json:"mode"`
+	RoomName   string         `json:"roomName"`
+	InviteCode string         `json:"inviteCode"`
+	Team       string         `json:"team"`
+	Striker    int            `json:"striker"`
+	IsHost     bool           `json:"isHost"`
+	UserNames  []string       `json:"userNames"`
+	Ratings    map[string]int `json:"ratings"` // keyed by user name; anonymous players are shown at the initial rating
+}
+
+type matchFailedPayload struct {
+	Channel    string `json:"channel"`
+	Mode       string `json:"mode"`
+	Message    string `json:"message"`
+	IsRequeued bool   `json:"isRequeued"` // false if the user's queue timed out meanwhile, or they entered a room
+}
+
+func playersPerMatch(mode string) (int, error) {
+	switch mode {
+	case "1v1":
+		return 2, nil
+	case "2v2":
+		return 4, nil
+	default:
+		return 0, fmt.Errorf("unknown matchmaking mode %q", mode)
+	}
+}
+
+func (mm *matchmaker) enqueue(userPtr *user, mode string) error {
+	playerCount, err := playersPerMatch(mode)
+	if err != nil {
+		return err
+	}
+
+	mm.mu.Lock()
+
+	// checked while holding mm.mu, as users who enter a room dequeue themselves right after
+	if roomPtr, _ := userPtr.getRoom(); roomPtr != nil {
+		mm.mu.Unlock()
+		return errors.New("user is already in a room")
+	} else if mm.find(userPtr) != nil {
+		mm.mu.Unlock()
+		return errors.New("user is already queued")
+	}
+
+	entry := &queuedUser{userPtr: userPtr, mode: mode, timestamp: time.Now()}
+	mm.queues[mode] = append(mm.queues[mode], entry)
+
+	err = userPtr.sendControl(queuedPayload{Channel: "queued", Mode: mode, Timeout: matchmakingTimeout})
+	if err != nil {
+		log.Printf("[ERROR] error while confirming that user %s is queued for %s. Reason: %v\n", userPtr.name, mode, err)
+	}
+	log.Printf("[INFO] user %s queued for %s\n", userPtr.name, mode)
+
+	group := mm.takeGroup(mode, playerCount)
+
+	mm.mu.Unlock()
+
+	if group != nil {
+		mm.createMatchRoom(mode, group)
+		return nil
+	}
+
+	time.AfterFunc(matchmakingTimeout*time.Second, func() {
+		if !mm.remove(entry) {
+			return
+		}
+
+		err := userPtr.sendControl(queueLeftPayload{Channel: "queueTimeout", Mode: mode})
+		if err != nil {
+			log.Printf("[ERROR] error while telling user %s that their queue for %s timed out. Reason: %v\n", userPtr.name, mode, err)
+		}
+		log.Printf("[INFO] queue of user %s for %s timed out\n", userPtr.name, mode)
+	})
+
+	return nil
+}
+
+func (mm *matchmaker) cancel(userPtr *user) error {
+	mm.mu.Lock()
+	entry := mm.find(userPtr)
+	mm.mu.Unlock()
+
+	if entry == nil || !mm.remove(entry) {
+		return errors.New("user is not queued")
+	}
+
+	log.Printf("[INFO] user %s left queue for %s\n", userPtr.name, entry.mode)
+	return userPtr.sendControl(queueLeftPayload{Channel: "queueCancelled", Mode: entry.mode})
+}
+
+// silently takes userPtr out of matchmaking, e.g. because they disconnected
+func (mm *matchmaker) dequeue(userPtr *user) {
+	mm.mu.Lock()
+	entry := mm.find(userPtr)
+	mm.mu.Unlock()
+
+	if entry != nil {
+		mm.remove(entry)
+	}
+}
+
+// only call while holding mm.mu
+func (mm *matchmaker) find(userPtr *user) *queuedUser {
+	for _, queue := range mm.queues {
+		for _, entry := range queue {
+			if entry.userPtr == userPtr {
+				return entry
+			}
+		}
+	}
+
+	return nil
+}
+
+// only call while holding mm.mu; returns the longest queued entries of mode once there are playerCount of them,
+// dropping entries of users who entered a room since they queued
+func (mm *matchmaker) takeGroup(mode string, playerCount int) []*queuedUser {
+	queue := mm.queues[mode][:0]
+	for _, entry := range mm.queues[mode] {
+		if roomPtr, _ := entry.userPtr.getRoom(); roomPtr == nil {
+			queue = append(queue, entry)
+		}
+	}
+	mm.queues[mode] = queue
+
+	if len(queue) < playerCount {
+		return nil
+	}
+
+	group := slices.Clone(queue[:playerCount])
+	mm.queues[mode] = queue[playerCount:]
+	return group
+}
+
+// puts entries of a match that fell through back at the front of their queue, so users keep their place in line;
+// entries whose queue timed out meanwhile, or whose user entered a room or left the server, are left out
+func (mm *matchmaker) requeue(mode string, entries []*queuedUser) []*queuedUser {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	var requeued []*queuedUser
+	for _, entry := range entries {
+		if matchmakingTimeout*time.Second <= time.Since(entry.timestamp) || mm.find(entry.userPtr) != nil {
+			continue
+		} else if roomPtr, _ := entry.userPtr.getRoom(); roomPtr != nil {
+			continue
+		} else if _, userPtr, err := users.find(entry.userPtr.name); err != nil || userPtr != entry.userPtr {
+			continue
+		}
+		requeued = append(requeued, entry)
+	}
+	mm.queues[mode] = append(slices.Clone(requeued), mm.queues[mode]...)
+
+	return requeued
+}
+
+// returns false if entry had already left its queue
+func (mm *matchmaker) remove(entry *queuedUser) bool {
+	mm.mu.Lock()
+	defer mm.mu.Unlock()
+
+	queue := mm.queues[entry.mode]
+	for i := range queue {
+		if queue[i] == entry {
+			mm.queues[entry.mode] = append(queue[:i], queue[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// puts a matched group of users in a new private room with teams balanced by rating; the longest queued user hosts
+func (mm *matchmaker) createMatchRoom(mode string, entries []*queuedUser) {
+	group := make([]*user, 0, len(entries))
+	for _, entry := range entries {
+		group = append(group, entry.userPtr)
+	}
+
+	// undoes whatever part of the match was set up, and puts everyone back in line
+	var newRoom *room
+	var addedUsers []*user
+	notifyFailure := func(err error) {
+		log.Printf("[ERROR] could not create room for %s match. Reason: %v\n", mode, err)
+
+		for _, userPtr := range addedUsers {
+			err := newRoom.deleteMember(userPtr)
+			if err != nil {
+				log.Printf("[ERROR] error while removing user %s from room %s of failed match. Reason: %v\n", userPtr.name, newRoom.name, err)
+			}
+			userPtr.setRoom(nil, false)
+		}
+
+		requeued := mm.requeue(mode, entries)
+		for _, userPtr := range group {
+			isRequeued := slices.ContainsFunc(requeued, func(entry *queuedUser) bool { return entry.userPtr == userPtr })
+			err := userPtr.sendControl(matchFailedPayload{Channel: "matchFailed", Mode: mode, Message: "could not create room for match", IsRequeued: isRequeued})
+			if err != nil {
+				log.Printf("[ERROR] error while telling user %s that their %s match failed. Reason: %v\n", userPtr.name, mode, err)
+			}
+		}
+	}
+
+	// a user may have entered a room since the group was taken from the queue
+	for _, userPtr := range group {
+		if roomPtr, _ := userPtr.getRoom(); roomPtr != nil {
+			notifyFailure(fmt.Errorf("user %s is already in a room", userPtr.name))
+			return
+		}
+	}
+
+	ratings := make(map[string]int, len(group))
+	for _, userPtr := range group {
+		ratings[userPtr.name] = profiles.rating(userPtr.name, mode)
+	}
+
+	// in 2v2 the best and worst rated players team up against the other two
+	byRating := slices.Clone(group)
+	slices.SortStableFunc(byRating, func(a, b *user) int {
+		return ratings[b.name] - ratings[a.name]
+	})
+	nextStriker := map[string]int{"left": 0, "right": 1}
+	for i, userPtr := range byRating {
+		userPtr.team = "right"
+		if i == 0 || i == 3 {
+			userPtr.team = "left"
+		}
+		userPtr.striker = nextStriker[userPtr.team]
+		nextStriker[userPtr.team] += 2
+	}
+
+	inviteCode, err := generateInviteCode()
+	if err != nil {
+		notifyFailure(err)
+		return
+	}
+
+	hostPtr := group[0]
+	payload := roomPayload{RoomName: "mm-" + inviteCode, UserName: hostPtr.name, Team: hostPtr.team, Striker: hostPtr.striker, IsPrivate: true}
+	err = validateRoomPayload(&payload)
+	if err != nil {
+		notifyFailure(err)
+		return
+	}
+
+	newRoom, err = createRoom(payload, hostPtr)
+	if err != nil {
+		notifyFailure(err)
+		return
+	}
+	addedUsers = append(addedUsers, hostPtr)
+
+	userNames := make([]string, 0, len(group))
+	for _, userPtr := range group {
+		userNames = append(userNames, userPtr.name)
+		if userPtr == hostPtr {
+			continue
+		}
+
+		err = newRoom.addMember(userPtr)
+		if err != nil {
+			notifyFailure(fmt.Errorf("could not add user %s to room %s: %w", userPtr.name, newRoom.name, err))
+			return
+		}
+		userPtr.setRoom(newRoom, false)
+		addedUsers = append(addedUsers, userPtr)
+	}
+
+	for _, userPtr := range group {
+		err = userPtr.sendControl(matchFoundPayload{
+			Channel:    "matchFound",
+			Mode:       mode,
+			RoomName:   newRoom.name,
+			InviteCode: newRoom.inviteCode,
+			Team:       userPtr.team,
+			Striker:    userPtr.striker,
+			IsHost:     userPtr == hostPtr,
+			UserNames:  userNames,
+			Ratings:    ratings,
+		})
+		if err != nil {
+			log.Printf("[ERROR] error while telling user %s about their %s match. Reason: %v\n", userPtr.name, mode, err)
+		}
+	}
+
+	log.Printf("[INFO] matched users %v in room %s for %s\n", userNames, newRoom.name, mode)
+}
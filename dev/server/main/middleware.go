@@ -1,10 +1,18 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"strings"
+	"time"
 )
 
+// name of a header like X-Forwarded-For or X-Real-IP set by a reverse proxy in front of the server; empty if clients connect directly
+var trustedProxyHeader string
+
 func middlewareChain(handler http.HandlerFunc) http.HandlerFunc {
 	function := rateLimitMiddleware()(handler)
 	function = memoryLimitMiddleware()(function)
@@ -14,9 +22,17 @@ func middlewareChain(handler http.HandlerFunc) http.HandlerFunc {
 func rateLimitMiddleware() func(http.HandlerFunc) http.HandlerFunc {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(writer http.ResponseWriter, req *http.Request) {
-			if isGloballyRateLimited() {
+			ip := clientIP(req)
+			if isAllowed, retryAfter := ipRateLimiter.take(ip); !isAllowed {
+				log.Printf("[ERROR] Request to %q from %s rate-limited\n", req.URL, ip)
+				tooManyRequests(writer, retryAfter)
+				return
+			}
+
+			// global limits stay as a safety net against many clients at once
+			if isLimited, retryAfter := isGloballyRateLimited(); isLimited {
 				log.Printf("[ERROR] Request to %q rate-limited\n", req.URL)
-				http.Error(writer, "Try again later", http.StatusTooManyRequests)
+				tooManyRequests(writer, retryAfter)
 				return
 			}
 
@@ -38,3 +54,44 @@ func memoryLimitMiddleware() func(http.HandlerFunc) http.HandlerFunc {
 		}
 	}
 }
+
+// limits handshakes per user name; only call once the name is known to belong to the client, as anyone could use up
+// the limit of a name they don't own otherwise. Guesses of session tokens and secrets are slowed down by the ip limit
+// and the cost of hashing secrets instead
+func rateLimitHandshake(userName string) error {
+	isAllowed, retryAfter := userRateLimiter.take(userName)
+	if !isAllowed {
+		log.Printf("[ERROR] handshake of user %s rate-limited\n", userName)
+		return fmt.Errorf("too many attempts, try again in %d seconds", retryAfterSeconds(retryAfter))
+	}
+
+	return nil
+}
+
+func tooManyRequests(writer http.ResponseWriter, retryAfter time.Duration) {
+	writer.Header().Set("Retry-After", fmt.Sprint(retryAfterSeconds(retryAfter)))
+	http.Error(writer, "Try again later", http.StatusTooManyRequests)
+}
+
+// Retry-After is measured in whole seconds, so round up to avoid clients retrying too early
+func retryAfterSeconds(retryAfter time.Duration) int {
+	return max(1, int(math.Ceil(retryAfter.Seconds())))
+}
+
+// the proxy header is only honoured when configured, since clients can set it to anything when connecting directly
+func clientIP(req *http.Request) string {
+	if trustedProxyHeader != "" {
+		// proxies append the address they received the request from, so the last entry is the one set by the trusted proxy
+		values := strings.Split(req.Header.Get(trustedProxyHeader), ",")
+		ip := net.ParseIP(strings.TrimSpace(values[len(values)-1]))
+		if ip != nil {
+			return ip.String()
+		}
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
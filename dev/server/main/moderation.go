@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"log"
+)
+
+type kickedPayload struct {
+	Channel  string `json:"channel"`
+	RoomName string `json:"roomName"`
+	IsBanned bool   `json:"isBanned"`
+}
+
+type memberKickedPayload struct {
+	Channel  string `json:"channel"` // "memberKicked" or "memberBanned"
+	UserName string `json:"userName"`
+}
+
+type roomLockedPayload struct {
+	Channel  string `json:"channel"`
+	IsLocked bool   `json:"isLocked"`
+}
+
+type hostTransferredPayload struct {
+	Channel      string `json:"channel"`
+	FromUserName string `json:"fromUserName"`
+	ToUserName   string `json:"toUserName"`
+}
+
+// removes the member or spectator named targetName from room on behalf of its host; a banned name can't join or spectate room again for as long as room exists
+func (room *room) kick(hostPtr *user, targetName string, isBan bool) error {
+	room.mu.Lock()
+
+	if room.host != hostPtr {
+		room.mu.Unlock()
+		return errors.New("only the host can kick or ban users")
+	} else if targetName == hostPtr.name {
+		room.mu.Unlock()
+		return errors.New("host can't kick or ban themselves")
+	}
+
+	var target *user
+	for _, userPtr := range room.audience() {
+		if userPtr.name == targetName {
+			target = userPtr
+			break
+		}
+	}
+	if target == nil {
+		room.mu.Unlock()
+		return errors.New("user is not in this room")
+	}
+
+	if isBan {
+		if room.bannedNames == nil {
+			room.bannedNames = make(map[string]bool)
+		}
+		room.bannedNames[targetName] = true
+	}
+
+	room.mu.Unlock()
+
+	err := target.sendControl(kickedPayload{Channel: "kicked", RoomName: room.name, IsBanned: isBan})
+	if err != nil {
+		log.Printf("[ERROR] error while telling user %s that they were kicked from room %s. Reason: %v\n", target.name, room.name, err)
+	}
+
+	if _, isSpectator := target.getRoom(); isSpectator {
+		err = room.deleteSpectator(target)
+	} else {
+		err = room.deleteMember(target)
+	}
+	if err != nil {
+		return err
+	}
+
+	// target's own goroutine reads their room while handling their messages
+	target.setRoom(nil, false)
+
+	channel := "memberKicked"
+	if isBan {
+		channel = "memberBanned"
+	}
+	room.broadcastControl(memberKickedPayload{Channel: channel, UserName: targetName})
+
+	log.Printf("[INFO] host %s removed user %s from room %s (banned: %v)\n", hostPtr.name, targetName, room.name, isBan)
+	return nil
+}
+
+// stops new players and spectators from entering room and hides it from the room list while locked
+func (room *room) setLocked(hostPtr *user, isLocked bool) error {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if room.host != hostPtr {
+		return errors.New("only the host can lock the room")
+	}
+
+	room.isLocked = isLocked
+	room.sendControlToAudience(roomLockedPayload{Channel: "roomLocked", IsLocked: isLocked})
+
+	log.Printf("[INFO] host %s set locked status of room %s to %v\n", hostPtr.name, room.name, isLocked)
+	return nil
+}
+
+func (room *room) transferHost(hostPtr *user, targetName string) error {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if room.host != hostPtr {
+		return errors.New("only the host can transfer the host role")
+	} else if targetName == hostPtr.name {
+		return errors.New("user is already the host")
+	}
+
+	_, target, err := room.members.find(targetName)
+	if err != nil || target.isBot {
+		return errors.New("only players in this room can become its host")
+	}
+
+	room.host = target
+	room.sendControlToAudience(hostTransferredPayload{Channel: "hostTransferred", FromUserName: hostPtr.name, ToUserName: targetName})
+
+	log.Printf("[INFO] transferred host of room %s from user %s to user %s\n", room.name, hostPtr.name, targetName)
+	return nil
+}
+
+// checks whether a user named userName may enter room as a player or spectator
+func (room *room) checkAdmission(userName string) error {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if room.bannedNames[userName] {
+		return errors.New("you are banned from this room")
+	} else if room.isLocked {
+		return errors.New("room is locked")
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+type pausePayload struct {
+	Channel        string `json:"channel"`
+	UserName       string `json:"userName"`
+	PausesLeft     int    `json:"pausesLeft"`
+	ServerTime     int64  `json:"serverTime"`     // unix time in milliseconds at which the match was paused
+	AutoResumeTime int64  `json:"autoResumeTime"` // unix time in milliseconds at which the match resumes unless someone resumes it earlier
+}
+
+type resumePayload struct {
+	Channel  string `json:"channel"`
+	UserName string `json:"userName"` // empty if the match was resumed automatically
+}
+
+func validatePauseSettings(pauseBudget int, pauseTimeout int) error {
+	if pauseBudget < 0 || maxPauseBudget < pauseBudget {
+		return fmt.Errorf("pause budget must be between 0 and %v", maxPauseBudget)
+	}
+
+	if pauseTimeout < 0 || maxPauseTimeout < pauseTimeout {
+		return fmt.Errorf("pause timeout must be between 0 and %v seconds", maxPauseTimeout)
+	}
+
+	return nil
+}
+
+func (room *room) pause(userPtr *user) error {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if room.phase != "playing" {
+		return errors.New("match can only be paused while playing")
+	}
+
+	if room.pausesUsed == nil {
+		room.pausesUsed = make(map[string]int, maxUsersPerRoom)
+	}
+	if room.pauseBudget == 0 {
+		return errors.New("pausing is disabled in this room")
+	} else if room.pauseBudget <= room.pausesUsed[userPtr.name] {
+		return errors.New("no pauses left")
+	}
+
+	now := time.Now()
+	room.pausesUsed[userPtr.name]++
+	room.phase = "paused"
+	room.pausedBy = userPtr.name
+	room.pauseTimestamp = now
+
+	room.sendControlToAudience(pausePayload{
+		Channel:        "pause",
+		UserName:       userPtr.name,
+		PausesLeft:     room.pauseBudget - room.pausesUsed[userPtr.name],
+		ServerTime:     now.UnixMilli(),
+		AutoResumeTime: now.Add(room.pauseTimeout).UnixMilli(),
+	})
+
+	log.Printf("[INFO] user %s paused match in room %s\n", userPtr.name, room.name)
+	return nil
+}
+
+func (room *room) resumeByUser(userPtr *user) error {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if room.phase != "paused" {
+		return errors.New("match is not paused")
+	} else if room.pausedBy != userPtr.name && room.host != userPtr {
+		return errors.New("only the player who paused or the host can resume")
+	}
+
+	room.resume(time.Now(), userPtr.name)
+	return nil
+}
+
+// only call while holding room.mu; an empty userName means the pause timed out
+func (room *room) resume(now time.Time, userName string) {
+	// freeze the match clock and goal celebration for as long as the match was paused
+	pausedDuration := now.Sub(room.pauseTimestamp)
+	room.matchStartTimestamp = room.matchStartTimestamp.Add(pausedDuration)
+	room.goalTimestamp = room.goalTimestamp.Add(pausedDuration)
+	room.pausedBy = ""
+
+	room.sendControlToAudience(resumePayload{Channel: "resume", UserName: userName})
+	room.startCountdown(now, "resume")
+
+	if userName == "" {
+		log.Printf("[INFO] match in room %s resumed automatically\n", room.name)
+	} else {
+		log.Printf("[INFO] user %s resumed match in room %s\n", userName, room.name)
+	}
+}
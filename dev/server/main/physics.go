@@ -0,0 +1,300 @@
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+)
+
+type puck struct {
+	xPos float64
+	yPos float64
+	xVel float64
+	yVel float64
+}
+
+type striker struct {
+	xPos                   float64
+	yPos                   float64
+	xVel                   float64
+	yVel                   float64
+	prevCollisionTimestamp time.Time
+}
+
+func (puck *puck) setXPos(xPos float64) {
+	radius := puckRadiusFraction * boardWidth
+	puck.xPos = clamp(-boardWidth-2*radius, xPos, boardWidth+2*radius)
+}
+
+func (puck *puck) setYPos(yPos float64) {
+	radius := puckRadiusFraction * boardWidth
+	puck.yPos = clamp(-boardHeight-2*radius, yPos, boardHeight+2*radius)
+}
+
+func (puck *puck) setXVel(xVel float64) {
+	puck.xVel = limitPuckSpeed(xVel)
+}
+
+func (puck *puck) setYVel(yVel float64) {
+	puck.yVel = limitPuckSpeed(yVel)
+}
+
+func (puck *puck) reset() {
+	puck.setXPos(boardWidth / 2)
+	puck.setYPos(boardHeight / 2)
+	puck.setXVel(0)
+	puck.setYVel(0)
+}
+
+// only call while holding room.mu
+func (room *room) updateStriker(currStatePtr *state) {
+	if room.strikers == nil {
+		room.strikers = make(map[string]*striker, maxUsersPerRoom)
+	}
+
+	strikerPtr, ok := room.strikers[currStatePtr.UserName]
+	if !ok {
+		strikerPtr = &striker{}
+		room.strikers[currStatePtr.UserName] = strikerPtr
+	}
+
+	strikerPtr.xPos = fromSignificantDigits(currStatePtr.PlayerXPos, boardWidth)
+	strikerPtr.yPos = fromSignificantDigits(currStatePtr.PlayerYPos, boardHeight)
+	strikerPtr.xVel = fromSignificantDigits(currStatePtr.PlayerXVel, boardWidth)
+	strikerPtr.yVel = fromSignificantDigits(currStatePtr.PlayerYVel, boardHeight)
+}
+
+// only call while holding room.mu
+func (room *room) resetRound() {
+	room.puck.reset()
+	room.forgetPuckFrames()
+	room.isGoal = false
+	room.stuckPuckTimestamp = time.Now()
+	room.wasPuckOnLeftSide = false
+
+	for _, userPtr := range room.members.slice {
+		if userPtr.isBot {
+			room.resetBot(userPtr)
+		}
+	}
+}
+
+// advances the room's puck by one fixed timestep, mirroring the client's Puck.update() and handleCollisions()
+func (room *room) step(now time.Time) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	// don't start game if host is alone in room
+	if len(room.members.slice) <= 1 {
+		if room.phase != "lobby" {
+			room.returnToLobby()
+		}
+		return
+	}
+
+	switch room.phase {
+	case "lobby":
+		return
+	case "paused":
+		if room.pauseTimeout <= now.Sub(room.pauseTimestamp) {
+			room.resume(now, "")
+		}
+		return
+	case "countdown":
+		if now.Before(room.kickoffTimestamp) {
+			return
+		}
+
+		room.phase = "playing"
+		room.stuckPuckTimestamp = now
+		if room.matchStartTimestamp.IsZero() {
+			room.matchStartTimestamp = now
+			room.matchRealStartTimestamp = now
+		}
+	}
+
+	// stop simulating once the match is over
+	if room.checkMatchOver(now) {
+		return
+	}
+
+	if room.isGoal {
+		if goalResetDelay*time.Millisecond <= now.Sub(room.goalTimestamp) {
+			room.resetRound()
+			room.startCountdown(now, "goal")
+			return
+		}
+
+		// let the puck cross goal post's width
+		room.movePuck()
+		return
+	}
+
+	room.movePuck()
+
+	// reset puck if it is stuck on one side of the board
+	isPuckOnLeftSide := room.puck.xPos < boardWidth/2
+	isPuckOnCentralLine := room.puck.xPos == boardWidth/2
+	if isPuckOnCentralLine || room.wasPuckOnLeftSide != isPuckOnLeftSide {
+		room.stuckPuckTimestamp = now
+	} else if stuckPuckMaxDuration*time.Second <= now.Sub(room.stuckPuckTimestamp) {
+		room.resetRound()
+		room.startCountdown(now, "stuckPuck")
+		return
+	}
+	room.wasPuckOnLeftSide = isPuckOnLeftSide
+
+	if room.handlePuckBoardCollisions(now) {
+		return
+	}
+	room.updateBots()
+	room.handlePuckStrikerCollisions(now)
+	room.recordPuckFrame(now)
+}
+
+// only call while holding room.mu
+func (room *room) movePuck() {
+	// slow down the puck using friction
+	room.puck.setXVel(room.puck.xVel * puckFriction)
+	room.puck.setYVel(room.puck.yVel * puckFriction)
+
+	// update position using velocity
+	room.puck.setXPos(room.puck.xPos + room.puck.xVel)
+	room.puck.setYPos(room.puck.yPos + room.puck.yVel)
+}
+
+// only call while holding room.mu; returns true if a goal was scored
+func (room *room) handlePuckBoardCollisions(now time.Time) bool {
+	radius := puckRadiusFraction * boardWidth
+	xBoardBoundStart := xBoardRinkFraction*boardWidth + radius
+	xBoardBoundEnd := boardWidth*(1-xBoardRinkFraction) - radius
+	yBoardBoundStart := yBoardRinkFraction*boardHeight + radius
+	yBoardBoundEnd := boardHeight*(1-yBoardRinkFraction) - radius
+	yGoalStart := yGoalStartFraction*boardHeight + 2*radius
+	yGoalEnd := yGoalEndFraction*boardHeight - 2*radius
+
+	// handle goal
+	if (room.puck.xPos < xBoardBoundStart || xBoardBoundEnd < room.puck.xPos) && yGoalStart < room.puck.yPos && room.puck.yPos < yGoalEnd {
+		room.handleGoal(now)
+		return true
+	}
+
+	// handle collision with board's rink
+	if room.puck.xPos <= xBoardBoundStart || xBoardBoundEnd <= room.puck.xPos {
+		room.puck.setXVel(-room.puck.xVel)
+	}
+	if room.puck.yPos <= yBoardBoundStart || yBoardBoundEnd <= room.puck.yPos {
+		room.puck.setYVel(-room.puck.yVel)
+	}
+
+	// handle edge case: if puck is stuck within rink area, reset its position
+	if room.puck.xPos < xBoardBoundStart {
+		room.puck.setXPos(xBoardBoundStart + 1)
+	}
+	if xBoardBoundEnd < room.puck.xPos {
+		room.puck.setXPos(xBoardBoundEnd - 1)
+	}
+	if room.puck.yPos < yBoardBoundStart {
+		room.puck.setYPos(yBoardBoundStart + 1)
+	}
+	if yBoardBoundEnd < room.puck.yPos {
+		room.puck.setYPos(yBoardBoundEnd - 1)
+	}
+
+	return false
+}
+
+// only call while holding room.mu
+func (room *room) handlePuckStrikerCollisions(now time.Time) {
+	for _, userPtr := range room.members.slice {
+		strikerPtr, ok := room.strikers[userPtr.name]
+		if !ok {
+			continue
+		}
+
+		dx := room.puck.xPos - strikerPtr.xPos
+		dy := room.puck.yPos - strikerPtr.yPos
+		distance := math.Sqrt(dx*dx + dy*dy)
+		radiiSum := (puckRadiusFraction + playerRadiusFraction) * boardWidth
+		isColliding := distance <= radiiSum && distance != 0
+
+		mustSkipCollision := now.Sub(strikerPtr.prevCollisionTimestamp) < puckPlayerCollisionCooldown*time.Millisecond
+
+		if !isColliding || mustSkipCollision {
+			continue
+		}
+
+		strikerPtr.prevCollisionTimestamp = now
+		room.puckHitTimestamp = now
+		room.bouncePuck(strikerPtr)
+	}
+}
+
+// only call while holding room.mu; assumes that strikerPtr touches the puck
+func (room *room) bouncePuck(strikerPtr *striker) {
+	dx := room.puck.xPos - strikerPtr.xPos
+	dy := room.puck.yPos - strikerPtr.yPos
+	distance := math.Sqrt(dx*dx + dy*dy)
+	radiiSum := (puckRadiusFraction + playerRadiusFraction) * boardWidth
+
+	// update velocities
+	cos := dx / distance
+	sin := dy / distance
+	strikerNormalVel := strikerPtr.xVel*cos + strikerPtr.yVel*sin
+	puckNormalVel := room.puck.xVel*cos + room.puck.yVel*sin
+	room.puck.setXVel(2*strikerNormalVel*cos - puckNormalVel*cos)
+	room.puck.setYVel(2*strikerNormalVel*sin - puckNormalVel*sin)
+
+	// teleport puck out of collision range
+	room.puck.setXPos(strikerPtr.xPos + dx*radiiSum/distance)
+	room.puck.setYPos(strikerPtr.yPos + dy*radiiSum/distance)
+}
+
+// only call while holding room.mu
+func (room *room) handleGoal(now time.Time) {
+	room.puck.setXVel(math.Copysign(math.Max(goalThresholdSpeed, math.Abs(room.puck.xVel)), room.puck.xVel))
+	room.puck.setYVel(0)
+
+	room.isGoal = true
+	room.goalTimestamp = now
+
+	if room.puck.xPos < boardWidth/2 {
+		room.rightScore++
+	} else {
+		room.leftScore++
+	}
+
+	log.Printf("[INFO] goal in room %s, score is %d-%d\n", room.name, room.leftScore, room.rightScore)
+}
+
+// only call while holding room.mu
+func (room *room) stampPuck(currStatePtr *state) {
+	currStatePtr.PuckXPos = toSignificantDigits(room.puck.xPos, boardWidth)
+	currStatePtr.PuckYPos = toSignificantDigits(room.puck.yPos, boardHeight)
+	currStatePtr.PuckXVel = toSignificantDigits(room.puck.xVel, boardWidth)
+	currStatePtr.PuckYVel = toSignificantDigits(room.puck.yVel, boardHeight)
+	currStatePtr.LeftScore = room.leftScore
+	currStatePtr.RightScore = room.rightScore
+}
+
+// util functions: not meant to be used outside this file
+func limitPuckSpeed(vel float64) float64 {
+	abs := math.Abs(vel)
+	if abs == 0 {
+		return 0
+	}
+
+	return math.Copysign(clamp(puckMinSpeed, abs, puckMaxSpeed), vel)
+}
+
+func clamp(low, value, high float64) float64 {
+	return math.Max(low, math.Min(value, high))
+}
+
+func fromSignificantDigits(digits int, dimension float64) float64 {
+	return float64(digits) / truncateFloatFactor * dimension
+}
+
+func toSignificantDigits(value float64, dimension float64) int {
+	return int(math.Floor(value / dimension * truncateFloatFactor))
+}
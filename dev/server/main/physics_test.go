@@ -0,0 +1,241 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// returns a room in which jomin on the left plays against suhan on the right, with the match started at matchStartTimestamp
+func newTestRoom(matchStartTimestamp time.Time) *room {
+	slice := []*user{
+		{name: "jomin", team: "left", striker: 0, controlQueue: make(chan []byte, controlQueueSize)},
+		{name: "suhan", team: "right", striker: 1, controlQueue: make(chan []byte, controlQueueSize)},
+	}
+
+	room := &room{
+		name:                "testroom",
+		host:                slice[0],
+		members:             &userArray{slice: slice},
+		spectators:          &userArray{},
+		strikers:            make(map[string]*striker),
+		phase:               "playing",
+		matchStartTimestamp: matchStartTimestamp,
+		stuckPuckTimestamp:  matchStartTimestamp,
+	}
+	room.puck.reset()
+	return room
+}
+
+func isClose(a float64, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestMovePuck(t *testing.T) {
+	tests := []struct {
+		name     string
+		puck     puck
+		wantPuck puck
+	}{
+		{"friction slows the puck", puck{xPos: 800, yPos: 450, xVel: 10, yVel: -10}, puck{xPos: 800 + 10*puckFriction, yPos: 450 - 10*puckFriction, xVel: 10 * puckFriction, yVel: -10 * puckFriction}},
+		{"slow puck keeps the min speed", puck{xPos: 800, yPos: 450, xVel: 0.5}, puck{xPos: 800 + puckMinSpeed, yPos: 450, xVel: puckMinSpeed}},
+		{"still puck stays still", puck{xPos: 800, yPos: 450}, puck{xPos: 800, yPos: 450}},
+	}
+
+	for _, test := range tests {
+		room := &room{puck: test.puck}
+		room.movePuck()
+
+		got := room.puck
+		if !isClose(got.xPos, test.wantPuck.xPos) || !isClose(got.yPos, test.wantPuck.yPos) || !isClose(got.xVel, test.wantPuck.xVel) || !isClose(got.yVel, test.wantPuck.yVel) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.wantPuck)
+		}
+	}
+}
+
+func TestHandlePuckBoardCollisions(t *testing.T) {
+	radius := puckRadiusFraction * boardWidth
+	xBoundStart := xBoardRinkFraction*boardWidth + radius
+	xBoundEnd := boardWidth*(1-xBoardRinkFraction) - radius
+	yBoundStart := yBoardRinkFraction*boardHeight + radius
+	yBoundEnd := boardHeight*(1-yBoardRinkFraction) - radius
+
+	tests := []struct {
+		name     string
+		puck     puck
+		wantPuck puck
+	}{
+		{"top wall", puck{xPos: 800, yPos: yBoundStart - 2, xVel: 3, yVel: -5}, puck{xPos: 800, yPos: yBoundStart + 1, xVel: 3, yVel: 5}},
+		{"bottom wall", puck{xPos: 800, yPos: yBoundEnd + 2, xVel: 3, yVel: 5}, puck{xPos: 800, yPos: yBoundEnd - 1, xVel: 3, yVel: -5}},
+		{"left wall beside the goal", puck{xPos: xBoundStart - 2, yPos: 100, xVel: -5, yVel: 3}, puck{xPos: xBoundStart + 1, yPos: 100, xVel: 5, yVel: 3}},
+		{"right wall beside the goal", puck{xPos: xBoundEnd + 2, yPos: 800, xVel: 5, yVel: 3}, puck{xPos: xBoundEnd - 1, yPos: 800, xVel: -5, yVel: 3}},
+		{"corner", puck{xPos: xBoundStart - 2, yPos: yBoundStart - 2, xVel: -5, yVel: -5}, puck{xPos: xBoundStart + 1, yPos: yBoundStart + 1, xVel: 5, yVel: 5}},
+		{"open board", puck{xPos: 800, yPos: 450, xVel: 5, yVel: 5}, puck{xPos: 800, yPos: 450, xVel: 5, yVel: 5}},
+	}
+
+	for _, test := range tests {
+		room := newTestRoom(time.Now())
+		room.puck = test.puck
+
+		if room.handlePuckBoardCollisions(time.Now()) {
+			t.Errorf("%s: got a goal", test.name)
+			continue
+		}
+
+		got := room.puck
+		if !isClose(got.xPos, test.wantPuck.xPos) || !isClose(got.yPos, test.wantPuck.yPos) || !isClose(got.xVel, test.wantPuck.xVel) || !isClose(got.yVel, test.wantPuck.yVel) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.wantPuck)
+		}
+	}
+}
+
+func TestGoal(t *testing.T) {
+	radius := puckRadiusFraction * boardWidth
+
+	tests := []struct {
+		name           string
+		puck           puck
+		wantLeftScore  int
+		wantRightScore int
+		wantXVel       float64
+	}{
+		{"slow puck into the left goal", puck{xPos: 5, yPos: 450, xVel: -2}, 0, 1, -goalThresholdSpeed},
+		{"fast puck into the right goal", puck{xPos: boardWidth - 5, yPos: 450, xVel: 15, yVel: 4}, 1, 0, 15},
+	}
+
+	for _, test := range tests {
+		now := time.Now()
+		room := newTestRoom(now)
+		room.puck = test.puck
+
+		if !room.handlePuckBoardCollisions(now) {
+			t.Errorf("%s: got no goal", test.name)
+			continue
+		}
+
+		if room.leftScore != test.wantLeftScore || room.rightScore != test.wantRightScore {
+			t.Errorf("%s: got score %d-%d, want %d-%d", test.name, room.leftScore, room.rightScore, test.wantLeftScore, test.wantRightScore)
+		} else if !room.isGoal || !room.goalTimestamp.Equal(now) {
+			t.Errorf("%s: goal wasn't recorded at now", test.name)
+		} else if room.puck.xVel != test.wantXVel || room.puck.yVel != 0 {
+			t.Errorf("%s: got velocity %v, %v, want %v, 0 so that the puck crosses the goal line", test.name, room.puck.xVel, room.puck.yVel, test.wantXVel)
+		}
+	}
+
+	// the goal posts keep a puck out that is beside the goal mouth
+	room := newTestRoom(time.Now())
+	room.puck = puck{xPos: 5, yPos: yGoalStartFraction*boardHeight + radius, xVel: -5}
+	if room.handlePuckBoardCollisions(time.Now()) {
+		t.Error("puck at the goal post got a goal")
+	}
+}
+
+func TestStepResetsGoalAfterDelay(t *testing.T) {
+	now := time.Now()
+	room := newTestRoom(now)
+	room.puck = puck{xPos: 5, yPos: 450, xVel: -goalThresholdSpeed}
+	room.step(now)
+	if !room.isGoal {
+		t.Fatal("puck in the goal didn't score")
+	}
+
+	// the puck keeps moving into the goal until the round is reset
+	room.step(now.Add(goalResetDelay*time.Millisecond - time.Millisecond))
+	if !room.isGoal || room.phase != "playing" || 0 <= room.puck.xPos {
+		t.Fatalf("round was reset too early, got phase %q and puck %+v", room.phase, room.puck)
+	}
+
+	room.step(now.Add(goalResetDelay * time.Millisecond))
+	if room.isGoal || room.phase != "countdown" || room.puck.xPos != boardWidth/2 || room.puck.yPos != boardHeight/2 {
+		t.Fatalf("got phase %q and puck %+v after the goal, want a countdown with the puck at the center", room.phase, room.puck)
+	}
+}
+
+func TestStepResetsStuckPuck(t *testing.T) {
+	now := time.Now()
+	room := newTestRoom(now)
+	room.puck = puck{xPos: 400, yPos: 450}
+	room.wasPuckOnLeftSide = true
+
+	room.step(now.Add(stuckPuckMaxDuration*time.Second - time.Millisecond))
+	if room.phase != "playing" || room.puck.xPos != 400 {
+		t.Fatalf("puck was reset too early, got phase %q and puck %+v", room.phase, room.puck)
+	}
+
+	room.step(now.Add(stuckPuckMaxDuration * time.Second))
+	if room.phase != "countdown" || room.puck.xPos != boardWidth/2 || room.puck.yPos != boardHeight/2 {
+		t.Fatalf("got phase %q and puck %+v, want a countdown with the puck at the center", room.phase, room.puck)
+	}
+}
+
+func TestStepKeepsPuckThatCrossesSides(t *testing.T) {
+	now := time.Now()
+	room := newTestRoom(now)
+	room.puck = puck{xPos: boardWidth/2 + 1, yPos: 450, xVel: -2}
+	room.wasPuckOnLeftSide = false
+
+	// crossing the central line restarts the stuck puck timer
+	room.step(now.Add(stuckPuckMaxDuration * time.Second))
+	if room.phase != "playing" || !room.stuckPuckTimestamp.Equal(now.Add(stuckPuckMaxDuration*time.Second)) {
+		t.Fatalf("got phase %q, want the puck that crossed sides to stay in play", room.phase)
+	}
+}
+
+func TestStrikerCollisionCooldown(t *testing.T) {
+	radiiSum := (puckRadiusFraction + playerRadiusFraction) * boardWidth
+	now := time.Now()
+	room := newTestRoom(now)
+	room.strikers["jomin"] = &striker{xPos: 745, yPos: 450}
+	overlappingPuck := puck{xPos: 745 + radiiSum - 5, yPos: 450, xVel: -5}
+
+	room.puck = overlappingPuck
+	room.handlePuckStrikerCollisions(now)
+	if room.puck.xVel != 5 || !room.puckHitTimestamp.Equal(now) || !room.strikers["jomin"].prevCollisionTimestamp.Equal(now) {
+		t.Fatalf("got puck %+v, want it to bounce off the striker at now", room.puck)
+	}
+
+	// a striker that keeps touching the puck doesn't hit it again right away
+	room.puck = overlappingPuck
+	room.handlePuckStrikerCollisions(now.Add(puckPlayerCollisionCooldown*time.Millisecond - time.Millisecond))
+	if room.puck != overlappingPuck {
+		t.Fatalf("got puck %+v during the cooldown, want it untouched", room.puck)
+	}
+
+	room.handlePuckStrikerCollisions(now.Add(puckPlayerCollisionCooldown * time.Millisecond))
+	if room.puck.xVel != 5 {
+		t.Fatalf("got puck %+v after the cooldown, want it to bounce again", room.puck)
+	}
+
+	// strikers of members without a state don't collide
+	room = newTestRoom(now)
+	room.puck = overlappingPuck
+	room.handlePuckStrikerCollisions(now)
+	if room.puck != overlappingPuck {
+		t.Fatalf("got puck %+v, want no collision without strikers", room.puck)
+	}
+}
+
+func TestBouncePuck(t *testing.T) {
+	radiiSum := (puckRadiusFraction + playerRadiusFraction) * boardWidth
+
+	tests := []struct {
+		name     string
+		puck     puck
+		striker  striker
+		wantPuck puck
+	}{
+		{"puck bounces off a still striker", puck{xPos: 800, yPos: 450, xVel: -5}, striker{xPos: 745, yPos: 450}, puck{xPos: 745 + radiiSum, yPos: 450, xVel: 5}},
+		{"moving striker pushes a still puck", puck{xPos: 800, yPos: 450}, striker{xPos: 745, yPos: 450, xVel: 3}, puck{xPos: 745 + radiiSum, yPos: 450, xVel: 6}},
+		{"speed is capped", puck{xPos: 800, yPos: 450}, striker{xPos: 800, yPos: 400, yVel: 50}, puck{xPos: 800, yPos: 400 + radiiSum, yVel: puckMaxSpeed}},
+	}
+
+	for _, test := range tests {
+		room := &room{puck: test.puck}
+		room.bouncePuck(&test.striker)
+
+		got := room.puck
+		if !isClose(got.xPos, test.wantPuck.xPos) || !isClose(got.yPos, test.wantPuck.yPos) || !isClose(got.xVel, test.wantPuck.xVel) || !isClose(got.yVel, test.wantPuck.yVel) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.wantPuck)
+		}
+	}
+}
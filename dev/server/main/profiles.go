@@ -0,0 +1,292 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+type profile struct {
+	Name        string         `json:"name"`
+	Salt        string         `json:"salt"`
+	SecretHash  string         `json:"secretHash"` // hex encoded argon2id hash of secret
+	Ratings     map[string]int `json:"ratings"`    // keyed by mode
+	MatchCounts map[string]int `json:"matchCounts"`
+	CreatedAt   int64          `json:"createdAt"` // unix time in seconds
+}
+
+// file-backed store of persistent player profiles; the whole store is rewritten at most every profileSaveDelay
+// seconds, which is fine for the at most maxProfileCount players a single server keeps
+type profileStore struct {
+	mu           sync.Mutex
+	path         string
+	profiles     map[string]*profile
+	isSaveQueued bool
+}
+
+var profiles = profileStore{profiles: make(map[string]*profile)}
+
+var ratedModes = []string{"1v1", "2v2"}
+
+func (store *profileStore) load(path string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.path = path
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(data, &store.profiles)
+	if err != nil {
+		return fmt.Errorf("could not parse profiles in %s. Reason: %v", path, err)
+	}
+
+	log.Printf("[INFO] loaded %d profiles from %s\n", len(store.profiles), path)
+	return nil
+}
+
+// only call while holding store.mu; collects the changes of the next profileSaveDelay seconds into a single save
+func (store *profileStore) queueSave() {
+	if store.isSaveQueued || store.path == "" {
+		return
+	}
+	store.isSaveQueued = true
+
+	time.AfterFunc(profileSaveDelay*time.Second, func() {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+
+		store.isSaveQueued = false
+		err := store.save()
+		if err != nil {
+			log.Println("[ERROR] could not save profiles. Reason:", err)
+		}
+	})
+}
+
+// only call while holding store.mu; writes to a temporary file first so that a crash never leaves a half-written store behind
+func (store *profileStore) save() error {
+	if store.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(store.profiles)
+	if err != nil {
+		return err
+	}
+
+	tempPath := store.path + ".tmp"
+	err = os.WriteFile(tempPath, data, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tempPath, store.path)
+}
+
+func (store *profileStore) exists(name string) bool {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	_, ok := store.profiles[name]
+	return ok
+}
+
+// verifies secret against name's profile, or creates the profile if name has none yet; secrets are hashed without
+// holding store.mu, as a slow hash is the point
+func (store *profileStore) claim(name string, secret string) error {
+	if secret == "" {
+		return errors.New("name belongs to a profile, so its secret is required")
+	} else if len(secret) < minSecretLength || maxSecretLength < len(secret) {
+		return fmt.Errorf("secret must be between %v and %v characters", minSecretLength, maxSecretLength)
+	}
+
+	store.mu.Lock()
+	profilePtr, ok := store.profiles[name]
+	var salt, secretHash string
+	if ok {
+		salt, secretHash = profilePtr.Salt, profilePtr.SecretHash
+	}
+	store.mu.Unlock()
+
+	if ok {
+		if subtle.ConstantTimeCompare([]byte(secretHash), []byte(hashSecret(salt, secret))) != 1 {
+			return errors.New("incorrect secret for this name")
+		}
+		return nil
+	}
+
+	salt, err := generateToken(saltLength)
+	if err != nil {
+		return err
+	}
+	secretHash = hashSecret(salt, secret)
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.profiles[name]; ok {
+		return errors.New("name was claimed by someone else just now")
+	} else if maxProfileCount <= len(store.profiles) {
+		return errors.New("server can't create more profiles")
+	}
+
+	profilePtr = &profile{
+		Name:        name,
+		Salt:        salt,
+		SecretHash:  secretHash,
+		Ratings:     make(map[string]int, len(ratedModes)),
+		MatchCounts: make(map[string]int, len(ratedModes)),
+		CreatedAt:   time.Now().Unix(),
+	}
+	for _, mode := range ratedModes {
+		profilePtr.Ratings[mode] = initialRating
+	}
+	store.profiles[name] = profilePtr
+	store.queueSave()
+
+	log.Printf("[INFO] created profile %s\n", name)
+	return nil
+}
+
+// returns name's rating for every rated mode, or nil if name has no profile
+func (store *profileStore) ratings(name string) map[string]int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	profilePtr, ok := store.profiles[name]
+	if !ok {
+		return nil
+	}
+
+	ratings := make(map[string]int, len(profilePtr.Ratings))
+	for mode, rating := range profilePtr.Ratings {
+		ratings[mode] = rating
+	}
+	return ratings
+}
+
+// returns name's rating for mode, falling back to the initial rating for anonymous players
+func (store *profileStore) rating(name string, mode string) int {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	profilePtr, ok := store.profiles[name]
+	if !ok {
+		return initialRating
+	}
+	return profilePtr.Ratings[mode]
+}
+
+// updates the Elo ratings of the players with profiles after a finished match; anonymous players count at the initial rating but aren't rated themselves
+func (store *profileStore) recordMatch(mode string, leftNames []string, rightNames []string, winner string) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	teamRating := func(names []string) float64 {
+		sum := 0
+		for _, name := range names {
+			if profilePtr, ok := store.profiles[name]; ok {
+				sum += profilePtr.Ratings[mode]
+			} else {
+				sum += initialRating
+			}
+		}
+		return float64(sum) / float64(len(names))
+	}
+
+	leftRating := teamRating(leftNames)
+	rightRating := teamRating(rightNames)
+	leftExpected := 1 / (1 + math.Pow(10, (rightRating-leftRating)/400))
+
+	leftActual := 0.5
+	if winner == "left" {
+		leftActual = 1
+	} else if winner == "right" {
+		leftActual = 0
+	}
+
+	leftDelta := int(math.Round(ratingKFactor * (leftActual - leftExpected)))
+
+	isChanged := false
+	applyDelta := func(names []string, delta int) {
+		for _, name := range names {
+			profilePtr, ok := store.profiles[name]
+			if !ok {
+				continue
+			}
+			profilePtr.Ratings[mode] += delta
+			profilePtr.MatchCounts[mode]++
+			isChanged = true
+		}
+	}
+	applyDelta(leftNames, leftDelta)
+	applyDelta(rightNames, -leftDelta)
+
+	if !isChanged {
+		return
+	}
+	store.queueSave()
+
+	log.Printf("[INFO] updated %s ratings of %v and %v by %+d and %+d\n", mode, leftNames, rightNames, leftDelta, -leftDelta)
+}
+
+type leaderboardEntry struct {
+	Rank       int    `json:"rank"`
+	Name       string `json:"name"`
+	Rating     int    `json:"rating"`
+	MatchCount int    `json:"matchCount"`
+}
+
+// returns the profiles that played at least one rated match of mode, best rated first
+func (store *profileStore) leaderboard(mode string, offset int, limit int) page[leaderboardEntry] {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	entries := make([]leaderboardEntry, 0, len(store.profiles))
+	for _, profilePtr := range store.profiles {
+		if profilePtr.MatchCounts[mode] == 0 {
+			continue
+		}
+		entries = append(entries, leaderboardEntry{Name: profilePtr.Name, Rating: profilePtr.Ratings[mode], MatchCount: profilePtr.MatchCounts[mode]})
+	}
+
+	slices.SortFunc(entries, func(a, b leaderboardEntry) int {
+		if a.Rating != b.Rating {
+			return b.Rating - a.Rating
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	// tied ratings share a rank
+	for i := range entries {
+		if 0 < i && entries[i].Rating == entries[i-1].Rating {
+			entries[i].Rank = entries[i-1].Rank
+		} else {
+			entries[i].Rank = i + 1
+		}
+	}
+
+	return paginate(entries, offset, limit)
+}
+
+func hashSecret(salt string, secret string) string {
+	hash := argon2.IDKey([]byte(secret), []byte(salt), argon2Time, argon2Memory, argon2Threads, argon2KeyLength)
+	return hex.EncodeToString(hash)
+}
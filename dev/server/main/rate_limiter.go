@@ -1,6 +1,7 @@
 package main
 
 import (
+	"math"
 	"sync"
 	"time"
 )
@@ -32,6 +33,14 @@ func (limiter *rateLimiter) isAllowed() bool {
 	return true
 }
 
+// time left until the current window ends
+func (limiter *rateLimiter) retryAfter() time.Duration {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	return max(0, limiter.windowDuration-time.Since(limiter.windowStartTimestamp))
+}
+
 var globalRateLimiters = []*rateLimiter{
 	{totalAllowed: reqPerSecond, windowDuration: time.Second},
 	{totalAllowed: reqPerMinute, windowDuration: time.Minute},
@@ -39,12 +48,118 @@ var globalRateLimiters = []*rateLimiter{
 	{totalAllowed: reqPerDay, windowDuration: 24 * time.Hour},
 }
 
-func isGloballyRateLimited() bool {
+// returns true along with how long to wait if any global limit is reached
+func isGloballyRateLimited() (bool, time.Duration) {
 	for _, rateLimiter := range globalRateLimiters {
 		if !rateLimiter.isAllowed() {
-			return true
+			return true, rateLimiter.retryAfter()
 		}
 	}
 
-	return false
+	return false, 0
+}
+
+type tokenBucket struct {
+	tokens          float64
+	refillTimestamp time.Time
+}
+
+// refills the bucket for the time passed since its last refill, then takes a token if there is one
+func (bucket *tokenBucket) take(now time.Time, burst float64, refillPerSecond float64) (bool, time.Duration) {
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.refillTimestamp).Seconds()*refillPerSecond)
+	bucket.refillTimestamp = now
+
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / refillPerSecond * float64(time.Second))
+	}
+
+	bucket.tokens--
+	return true, 0
+}
+
+// token buckets keyed by client, e.g. by ip or user name; each bucket holds up to burst tokens and
+// regains refillPerSecond tokens every second, and buckets unused for idleTimeout are evicted
+type keyedRateLimiter struct {
+	// constants
+	mu              sync.Mutex
+	burst           float64
+	refillPerSecond float64
+	idleTimeout     time.Duration
+	// variables
+	buckets map[string]*tokenBucket
+}
+
+// takes a token from key's bucket; if it is empty, returns false along with how long until a token is available
+func (limiter *keyedRateLimiter) take(key string) (bool, time.Duration) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if limiter.buckets == nil {
+		limiter.buckets = make(map[string]*tokenBucket)
+	}
+
+	now := time.Now()
+	bucket, ok := limiter.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: limiter.burst, refillTimestamp: now}
+		limiter.buckets[key] = bucket
+	}
+
+	return bucket.take(now, limiter.burst, limiter.refillPerSecond)
+}
+
+func (limiter *keyedRateLimiter) evictIdle() {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := time.Now()
+	for key, bucket := range limiter.buckets {
+		if limiter.idleTimeout <= now.Sub(bucket.refillTimestamp) {
+			delete(limiter.buckets, key)
+		}
+	}
+}
+
+var ipRateLimiter = &keyedRateLimiter{burst: ipBurst, refillPerSecond: ipReqPerSecond, idleTimeout: rateLimiterIdleTimeout * time.Second}
+
+var userRateLimiter = &keyedRateLimiter{burst: userBurst, refillPerSecond: userReqPerSecond, idleTimeout: rateLimiterIdleTimeout * time.Second}
+
+func evictIdleRateLimitersPeriodically() {
+	ticker := time.NewTicker(rateLimiterIdleTimeout * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ipRateLimiter.evictIdle()
+		userRateLimiter.evictIdle()
+	}
+}
+
+// limits the messages read from a single web socket connection; only use from that connection's read loop
+type messageRateLimiter struct {
+	bucket               tokenBucket
+	windowStartTimestamp time.Time
+	droppedInWindow      int
+}
+
+func newMessageRateLimiter() *messageRateLimiter {
+	now := time.Now()
+	return &messageRateLimiter{bucket: tokenBucket{tokens: messageBurst, refillTimestamp: now}, windowStartTimestamp: now}
+}
+
+// enforces the limit gradually: excess messages are dropped at first, and a connection that keeps
+// exceeding the limit must be disconnected
+func (limiter *messageRateLimiter) check() (isAllowed bool, mustDisconnect bool) {
+	now := time.Now()
+	isAllowed, _ = limiter.bucket.take(now, messageBurst, messagesPerSecond)
+	if isAllowed {
+		return true, false
+	}
+
+	if droppedMessagesWindow*time.Second <= now.Sub(limiter.windowStartTimestamp) {
+		limiter.windowStartTimestamp = now
+		limiter.droppedInWindow = 0
+	}
+	limiter.droppedInWindow++
+
+	return false, maxDroppedMessages < limiter.droppedInWindow
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	bucket := tokenBucket{tokens: 3, refillTimestamp: now}
+
+	// the burst is available right away
+	for i := 0; i < 3; i++ {
+		if ok, _ := bucket.take(now, 3, 2); !ok {
+			t.Fatalf("take %d of the burst failed", i+1)
+		}
+	}
+
+	ok, retryAfter := bucket.take(now, 3, 2)
+	if ok {
+		t.Fatal("take from an empty bucket succeeded")
+	} else if retryAfter != 500*time.Millisecond {
+		t.Fatalf("got retry after %v, want 500ms at 2 tokens per second", retryAfter)
+	}
+
+	// half a token isn't enough, and the wait shrinks accordingly
+	now = now.Add(250 * time.Millisecond)
+	ok, retryAfter = bucket.take(now, 3, 2)
+	if ok {
+		t.Fatal("take with half a token succeeded")
+	} else if retryAfter != 250*time.Millisecond {
+		t.Fatalf("got retry after %v, want 250ms", retryAfter)
+	}
+
+	now = now.Add(250 * time.Millisecond)
+	if ok, _ := bucket.take(now, 3, 2); !ok {
+		t.Fatal("take after refilling a token failed")
+	}
+
+	// an idle bucket refills up to the burst, never beyond
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := bucket.take(now, 3, 2); !ok {
+			t.Fatalf("take %d after idling failed", i+1)
+		}
+	}
+	if ok, _ := bucket.take(now, 3, 2); ok {
+		t.Fatal("idle bucket held more than the burst")
+	}
+}
+
+func TestKeyedRateLimiter(t *testing.T) {
+	limiter := &keyedRateLimiter{burst: 2, refillPerSecond: 0.001, idleTimeout: time.Hour}
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := limiter.take("1.2.3.4"); !ok {
+			t.Fatalf("take %d of the burst failed", i+1)
+		}
+	}
+	if ok, retryAfter := limiter.take("1.2.3.4"); ok || retryAfter <= 0 {
+		t.Fatalf("got %v with retry after %v from an empty bucket, want false with a positive wait", ok, retryAfter)
+	}
+
+	// keys don't share buckets
+	if ok, _ := limiter.take("5.6.7.8"); !ok {
+		t.Fatal("take with another key failed")
+	}
+
+	// buckets that are still in use survive eviction
+	limiter.evictIdle()
+	if len(limiter.buckets) != 2 {
+		t.Fatalf("got %d buckets after evicting, want 2", len(limiter.buckets))
+	}
+
+	limiter.idleTimeout = 0
+	limiter.evictIdle()
+	if len(limiter.buckets) != 0 {
+		t.Fatalf("got %d buckets after evicting idle ones, want 0", len(limiter.buckets))
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recordings are gzip compressed streams of the magic header followed by frames, each frame being
+// a uvarint offset in milliseconds since the recording started, a uvarint length and that many bytes of snapshot json
+const recordingMagic = "GOALREC\x01"
+
+var recordingsDir string
+
+var recordingIdRegexp = regexp.MustCompile(`^[0-9]+-[0-9a-f]+$`)
+
+type frame struct {
+	offset uint64 // measured in milliseconds since the recording started
+	data   []byte
+}
+
+type recorder struct {
+	id             string
+	startTimestamp time.Time
+	frames         chan frame
+}
+
+// the id of a recording of a private room is long enough to be unguessable, so only those it is shared with can watch it
+func startRecording(now time.Time, isPrivate bool) (*recorder, error) {
+	if recordingsDir == "" {
+		return nil, errors.New("recordings directory is not set")
+	}
+
+	suffixLength := 4
+	if isPrivate {
+		suffixLength = privateRecordingIdLength
+	}
+	suffix, err := generateToken(suffixLength)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &recorder{
+		id:             fmt.Sprintf("%d-%s", now.UnixMilli(), suffix),
+		startTimestamp: now,
+		frames:         make(chan frame, recorderQueueSize),
+	}
+
+	file, err := os.Create(recordingPath(rec.id))
+	if err != nil {
+		return nil, err
+	}
+
+	go rec.write(file)
+	return rec, nil
+}
+
+// queues a snapshot for writing without blocking; snapshots are dropped if the disk can't keep up
+func (rec *recorder) record(now time.Time, data []byte) {
+	select {
+	case rec.frames <- frame{offset: uint64(now.Sub(rec.startTimestamp).Milliseconds()), data: data}:
+	default:
+		log.Printf("[ERROR] recording %s can't keep up, dropping a snapshot\n", rec.id)
+	}
+}
+
+// finishes the recording in the background once every queued state is written
+func (rec *recorder) stop() {
+	close(rec.frames)
+}
+
+func (rec *recorder) write(file *os.File) {
+	defer file.Close()
+
+	bufferedWriter := bufio.NewWriter(file)
+	gzipWriter := gzip.NewWriter(bufferedWriter)
+
+	_, err := io.WriteString(gzipWriter, recordingMagic)
+	if err != nil {
+		log.Printf("[ERROR] could not write recording %s. Reason: %v\n", rec.id, err)
+		return
+	}
+
+	header := make([]byte, 2*binary.MaxVarintLen64)
+	for currFrame := range rec.frames {
+		if err != nil {
+			continue // keep draining so that record() never blocks
+		}
+
+		n := binary.PutUvarint(header, currFrame.offset)
+		n += binary.PutUvarint(header[n:], uint64(len(currFrame.data)))
+		_, err = gzipWriter.Write(header[:n])
+		if err == nil {
+			_, err = gzipWriter.Write(currFrame.data)
+		}
+		if err != nil {
+			log.Printf("[ERROR] could not write recording %s. Reason: %v\n", rec.id, err)
+		}
+	}
+
+	err = gzipWriter.Close()
+	if err == nil {
+		err = bufferedWriter.Flush()
+	}
+	if err != nil {
+		log.Printf("[ERROR] could not finish recording %s. Reason: %v\n", rec.id, err)
+		return
+	}
+
+	log.Printf("[INFO] finished recording %s\n", rec.id)
+}
+
+func recordingPath(id string) string {
+	return filepath.Join(recordingsDir, id+".rec.gz")
+}
+
+type recordingReader struct {
+	file       *os.File
+	gzipReader *gzip.Reader
+	reader     *bufio.Reader
+}
+
+func openRecording(id string) (*recordingReader, error) {
+	if !recordingIdRegexp.MatchString(id) {
+		return nil, errors.New("invalid recording id")
+	}
+
+	file, err := os.Open(recordingPath(id))
+	if err != nil {
+		return nil, err
+	}
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	recReader := &recordingReader{file: file, gzipReader: gzipReader, reader: bufio.NewReader(gzipReader)}
+
+	magic := make([]byte, len(recordingMagic))
+	_, err = io.ReadFull(recReader.reader, magic)
+	if err != nil || string(magic) != recordingMagic {
+		recReader.close()
+		return nil, errors.New("not a recording")
+	}
+
+	return recReader, nil
+}
+
+// returns io.EOF once every frame is read
+func (recReader *recordingReader) next() (frame, error) {
+	offset, err := binary.ReadUvarint(recReader.reader)
+	if err != nil {
+		return frame{}, err
+	}
+
+	length, err := binary.ReadUvarint(recReader.reader)
+	if err != nil {
+		return frame{}, io.ErrUnexpectedEOF
+	} else if maxRecordingFrameSize < length {
+		return frame{}, errors.New("recorded snapshot is too large")
+	}
+
+	data := make([]byte, length)
+	_, err = io.ReadFull(recReader.reader, data)
+	if err != nil {
+		return frame{}, io.ErrUnexpectedEOF
+	}
+
+	return frame{offset: offset, data: data}, nil
+}
+
+func (recReader *recordingReader) close() {
+	recReader.gzipReader.Close()
+	recReader.file.Close()
+}
+
+// only call while holding room.mu
+func (room *room) startRecording(now time.Time) {
+	if !room.isRecorded {
+		return
+	}
+
+	room.stopRecording()
+
+	var err error
+	room.recorder, err = startRecording(now, room.isPrivate)
+	if err != nil {
+		log.Printf("[ERROR] could not start recording match in room %s. Reason: %v\n", room.name, err)
+		return
+	}
+
+	log.Printf("[INFO] recording match in room %s as %s\n", room.name, room.recorder.id)
+}
+
+// only call while holding room.mu
+func (room *room) stopRecording() {
+	if room.recorder == nil {
+		return
+	}
+
+	room.recorder.stop()
+	room.recorder = nil
+}
+
+// deletes recordings older than maxRecordingAge days, and all but the newest maxRecordingCount; a recording's id
+// starts with the unix time in milliseconds at which it started
+func pruneRecordings(now time.Time) {
+	entries, err := os.ReadDir(recordingsDir)
+	if err != nil {
+		log.Println("[ERROR] could not list recordings. Reason:", err)
+		return
+	}
+
+	type storedRecording struct {
+		id        string
+		startTime int64
+	}
+
+	var recordings []storedRecording
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".rec.gz")
+		if !ok || !recordingIdRegexp.MatchString(id) {
+			continue
+		}
+		startTime, err := strconv.ParseInt(id[:strings.IndexByte(id, '-')], 10, 64)
+		if err != nil {
+			continue
+		}
+		recordings = append(recordings, storedRecording{id: id, startTime: startTime})
+	}
+
+	// newest first
+	slices.SortFunc(recordings, func(a, b storedRecording) int {
+		return cmp.Compare(b.startTime, a.startTime)
+	})
+
+	oldestStartTime := now.AddDate(0, 0, -maxRecordingAge).UnixMilli()
+	for i, rec := range recordings {
+		if i < maxRecordingCount && oldestStartTime <= rec.startTime {
+			continue
+		}
+
+		err := os.Remove(recordingPath(rec.id))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[ERROR] could not delete recording %s. Reason: %v\n", rec.id, err)
+			continue
+		}
+		log.Printf("[INFO] deleted recording %s\n", rec.id)
+	}
+}
+
+func pruneRecordingsPeriodically() {
+	ticker := time.NewTicker(recordingPruneInterval * time.Second)
+	defer ticker.Stop()
+
+	pruneRecordings(time.Now())
+	for now := range ticker.C {
+		pruneRecordings(now)
+	}
+}
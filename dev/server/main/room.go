@@ -1,10 +1,15 @@
 package main
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
+	"time"
 )
 
 type room struct {
@@ -12,9 +17,54 @@ type room struct {
 	name           string
 	host           *user
 	members        *userArray
+	spectators     *userArray
 	leftTeamCount  int
 	rightTeamCount int
 	stateChannel   chan *state
+	doneChannel    chan struct{} // closed when room is deleted; stateChannel stays open, as sending a late state to it would panic
+	tickRate       int           // snapshots broadcast per second
+	latestStates   map[string]*state
+	snapshotTick   uint64
+	snapshotFrames [deltaHistorySize]snapshotFrame // recent snapshots, indexed by tick modulo deltaHistorySize
+	// access
+	isPrivate    bool
+	passwordHash []byte
+	inviteCode   string
+	isLocked     bool
+	bannedNames  map[string]bool
+	// physics
+	puck               puck
+	strikers           map[string]*striker
+	leftScore          int
+	rightScore         int
+	isGoal             bool
+	goalTimestamp      time.Time
+	stuckPuckTimestamp time.Time
+	wasPuckOnLeftSide  bool
+	physicsTick        uint64
+	puckFrames         [puckHistorySize]puckFrame // recent puck positions for lag compensation, indexed by physicsTick modulo puckHistorySize
+	puckHitTimestamp   time.Time                  // latest time the puck was hit by a striker
+	// lobby
+	phase            string // "lobby", "countdown", "playing" or "paused"
+	readiness        map[string]bool
+	kickoffTimestamp time.Time
+	// match
+	rules                   matchRules
+	matchStartTimestamp     time.Time // shifted forward by pauses, so that only play counts towards the time limit
+	matchRealStartTimestamp time.Time // when the match kicked off, for the match history
+	isSuddenDeath           bool
+	isMatchOver             bool
+	// pause
+	pauseBudget    int // pauses allowed per player per match
+	pauseTimeout   time.Duration
+	pausesUsed     map[string]int
+	pausedBy       string
+	pauseTimestamp time.Time
+	// recording
+	isRecorded bool // whether matches in room are recorded for replay
+	recorder   *recorder
+	// chat
+	chatHistory []chatMessage // most recent messages, oldest first
 }
 
 func (room *room) memberCount() int {
@@ -29,6 +79,13 @@ func (room *room) getTeamCounts() (int, int) {
 	return room.leftTeamCount, room.rightTeamCount
 }
 
+// returns whether room is kept out of the room list, along with its team counts and rules
+func (room *room) getListing() (bool, int, int, matchRules) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	return room.isPrivate || room.isLocked, room.leftTeamCount, room.rightTeamCount, room.rules
+}
+
 func (room *room) addMember(userPtr *user) error {
 	room.mu.Lock()
 	defer room.mu.Unlock()
@@ -47,6 +104,9 @@ func (room *room) addMember(userPtr *user) error {
 	if err != nil {
 		return err
 	}
+	room.resetSnapshotBaseline(userPtr)
+	userPtr.stateStream = stateStream{}
+	userPtr.strikerFrames = [strikerHistorySize]strikerFrame{}
 
 	if userPtr.team == "left" {
 		room.leftTeamCount++
@@ -57,6 +117,37 @@ func (room *room) addMember(userPtr *user) error {
 	return nil
 }
 
+func (room *room) spectatorCount() int {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	return room.spectators.len()
+}
+
+func (room *room) addSpectator(userPtr *user) error {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if room.spectators.len() == maxSpectatorsPerRoom {
+		return fmt.Errorf("there are already %v spectators in room", maxSpectatorsPerRoom)
+	}
+
+	room.resetSnapshotBaseline(userPtr)
+	return room.spectators.add(userPtr)
+}
+
+func (room *room) deleteSpectator(leavingUser *user) error {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	err := room.spectators.deleteUsingName(leavingUser.name)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] deleted spectator %s from room %s\n", leavingUser.name, room.name)
+	return nil
+}
+
 func (room *room) deleteMember(leavingUser *user) error {
 	room.mu.Lock()
 	defer room.mu.Unlock()
@@ -74,6 +165,11 @@ func (room *room) deleteMember(leavingUser *user) error {
 		room.rightTeamCount--
 	}
 
+	// forget leavingUser's striker and latest state
+	delete(room.strikers, leavingUser.name)
+	delete(room.latestStates, leavingUser.name)
+	delete(room.readiness, leavingUser.name)
+
 	// reassign host if leavingUser was their room's host
 	if room.host == leavingUser {
 		room.reassignHost(leavingUser)
@@ -82,10 +178,13 @@ func (room *room) deleteMember(leavingUser *user) error {
 	// broadcast to all room members that leavingUser has left the room
 	room.broadcastMemberLeft(leavingUser)
 
-	log.Printf("[INFO] deleted member %s from room %s\n", leavingUser.name, room.name)
+	stream := leavingUser.stateStream
+	log.Printf("[INFO] deleted member %s from room %s after receiving %d states, %d lost, %d reordered, %d duplicated, %d skipped\n", leavingUser.name, room.name, stream.received, stream.lost, stream.reordered, stream.duplicated, stream.skipped)
 
-	// delete room if it became empty after deleting leavingUser
-	if len(room.members.slice) == 0 {
+	// delete room if it became empty after deleting leavingUser; bots don't keep a room alive
+	if room.hasOnlyBots() {
+		room.stopRecording()
+		room.dismissSpectators()
 		err = rooms.deleteUsingName(room.name)
 		if err != nil {
 			return err
@@ -95,6 +194,41 @@ func (room *room) deleteMember(leavingUser *user) error {
 	return nil
 }
 
+// only call from within room.deleteMember() to ensure proper room locking
+func (room *room) dismissSpectators() {
+	if room.spectators == nil {
+		return
+	}
+
+	type roomClosedPayload struct {
+		Channel  string `json:"channel"`
+		RoomName string `json:"roomName"`
+	}
+
+	for _, userPtr := range room.spectators.slice {
+		err := userPtr.sendControl(roomClosedPayload{Channel: "roomClosed", RoomName: room.name})
+		if err != nil {
+			log.Printf("[ERROR] error while communicating to spectator %s that room %s closed. Reason: %v\n", userPtr.name, room.name, err)
+		}
+
+		userPtr.setRoom(nil, false)
+	}
+
+	room.spectators.slice = room.spectators.slice[:0]
+}
+
+// only call while holding room.mu; returns members followed by spectators
+func (room *room) audience() []*user {
+	if room.spectators == nil {
+		return room.members.slice
+	}
+
+	audience := make([]*user, 0, len(room.members.slice)+len(room.spectators.slice))
+	audience = append(audience, room.members.slice...)
+	audience = append(audience, room.spectators.slice...)
+	return audience
+}
+
 // only call from within room.deleteMember() to ensure proper room locking
 func (room *room) broadcastMemberLeft(leavingUserPtr *user) {
 	if len(room.members.slice) == 0 {
@@ -108,8 +242,8 @@ func (room *room) broadcastMemberLeft(leavingUserPtr *user) {
 
 	payload := memberLeftPayload{Channel: "memberLeft", UserName: leavingUserPtr.name}
 
-	for _, userPtr := range room.members.slice {
-		err := userPtr.conn.WriteJSON(payload)
+	for _, userPtr := range room.audience() {
+		err := userPtr.sendControl(payload)
 		if err != nil {
 			log.Printf("[ERROR] error while communicating to user %s that user %s left room %s. Reason: %v\n", userPtr.name, leavingUserPtr.name, room.name, err)
 		} else {
@@ -130,15 +264,65 @@ func (room *room) reassignHost(leavingUserPtr *user) {
 		Channel string `json:"channel"`
 	}
 
+	// the longest-standing human member becomes host, whether or not the notification can be queued
 	for _, userPtr := range room.members.slice {
-		payload := reassignHostPayload{Channel: "reassignHost"}
-		err := userPtr.conn.WriteJSON(payload)
+		if userPtr.isBot {
+			continue
+		}
+
+		room.host = userPtr
+		log.Printf("[INFO] reassigned host of room %s from user %s to user %s\n", room.name, leavingUserPtr.name, userPtr.name)
+
+		err := userPtr.sendControl(reassignHostPayload{Channel: "reassignHost"})
 		if err != nil {
-			log.Printf("[ERROR] error while reassigning host of room %s from user %s to user %s. Reason: %v\n", room.name, leavingUserPtr.name, userPtr.name, err)
-		} else {
-			room.host = userPtr
-			log.Printf("[INFO] reassigned host of room %s from user %s to user %s\n", room.name, leavingUserPtr.name, userPtr.name)
-			break
+			log.Printf("[ERROR] error while notifying user %s that they are the new host of room %s. Reason: %v\n", userPtr.name, room.name, err)
+		}
+		return
+	}
+}
+
+// private rooms can only be joined with the room's password or invite code
+func (room *room) checkSecret(password string, inviteCode string) error {
+	if !room.isPrivate {
+		return nil
+	}
+
+	if room.passwordHash != nil {
+		passwordHash := sha256.Sum256([]byte(password))
+		if subtle.ConstantTimeCompare(room.passwordHash, passwordHash[:]) == 1 {
+			return nil
+		}
+	} else if inviteCode != "" && subtle.ConstantTimeCompare([]byte(room.inviteCode), []byte(strings.ToUpper(inviteCode))) == 1 {
+		return nil
+	}
+
+	return errors.New("incorrect password or invite code")
+}
+
+func (room *room) isHost(userPtr *user) bool {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	return room.host == userPtr
+}
+
+type memberConnectivityPayload struct {
+	Channel     string `json:"channel"`
+	UserName    string `json:"userName"`
+	GracePeriod int    `json:"gracePeriod,omitempty"` // measured in seconds
+}
+
+func (room *room) broadcastControl(payload any) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	room.sendControlToAudience(payload)
+}
+
+// only call while holding room.mu
+func (room *room) sendControlToAudience(payload any) {
+	for _, userPtr := range room.audience() {
+		err := userPtr.sendControl(payload)
+		if err != nil {
+			log.Printf("[ERROR] error while broadcasting to user %s in room %s. Reason: %v\n", userPtr.name, room.name, err)
 		}
 	}
 }
@@ -167,12 +351,52 @@ func (room *room) getAvailableStrikers() []int {
 }
 
 func (room *room) consumeState() {
-	for currStatePtr := range room.stateChannel {
-		room.broadcast(currStatePtr)
+	room.mu.Lock()
+	room.returnToLobby()
+	room.mu.Unlock()
+
+	physicsTicker := time.NewTicker(time.Second / physicsTickRate)
+	defer physicsTicker.Stop()
+	broadcastTicker := time.NewTicker(time.Second / time.Duration(room.tickRate))
+	defer broadcastTicker.Stop()
+
+	for {
+		select {
+		case <-room.doneChannel:
+			return
+		case currStatePtr := <-room.stateChannel:
+			room.storeState(currStatePtr)
+		case now := <-physicsTicker.C:
+			room.step(now)
+		case <-broadcastTicker.C:
+			room.broadcastSnapshot()
+		}
 	}
 }
 
-func (room *room) broadcast(currStatePtr *state) {
+// hands an accepted state to room's consumer, dropping it if room was deleted in the meantime
+func (room *room) forwardState(currStatePtr *state) {
+	select {
+	case room.stateChannel <- currStatePtr:
+	case <-room.doneChannel:
+	}
+}
+
+// keeps only the latest state of each member until the next snapshot is broadcast
+func (room *room) storeState(currStatePtr *state) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if room.latestStates == nil {
+		room.latestStates = make(map[string]*state, maxUsersPerRoom)
+	}
+
+	// feed sender's striker into the simulation
+	room.updateStriker(currStatePtr)
+	room.latestStates[currStatePtr.UserName] = currStatePtr
+}
+
+func (room *room) broadcastSnapshot() {
 	room.mu.Lock()
 	defer room.mu.Unlock()
 
@@ -180,19 +404,176 @@ func (room *room) broadcast(currStatePtr *state) {
 		return
 	}
 
+	room.snapshotTick++
+	currSnapshot := snapshot{Channel: "snapshot", Tick: room.snapshotTick, Phase: room.phase, States: make([]*state, 0, len(room.members.slice))}
+
+	for _, userPtr := range room.members.slice {
+		currStatePtr, ok := room.latestStates[userPtr.name]
+		if !ok {
+			continue
+		}
+
+		// set the state.isHost field, then overwrite the puck and scores reported by the client with the server's authoritative values
+		currStatePtr.IsHost = userPtr == room.host
+		room.stampPuck(currStatePtr)
+
+		currSnapshot.States = append(currSnapshot.States, currStatePtr)
+	}
+
+	if len(currSnapshot.States) == 0 {
+		return
+	}
+
+	// encode once, write the same bytes to every member that doesn't receive deltas
+	data, err := json.Marshal(currSnapshot)
+	if err != nil {
+		log.Printf("[ERROR] error encoding snapshot of room %s. Reason: %v\n", room.name, err)
+		return
+	}
+
+	room.storeSnapshotFrame(&currSnapshot)
+	room.sendSnapshot(&currSnapshot, data)
+
+	// replays are streamed as the full snapshots members who don't receive deltas get
+	if room.recorder != nil {
+		room.recorder.record(time.Now(), data)
+	}
+}
+
+// returns the ratings of members with a profile, keyed by user name, or nil if no member has one
+func (room *room) memberRatings() map[string]map[string]int {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	var ratings map[string]map[string]int
+	for _, userPtr := range room.members.slice {
+		userRatings := profiles.ratings(userPtr.name)
+		if userRatings == nil {
+			continue
+		}
+		if ratings == nil {
+			ratings = make(map[string]map[string]int, maxUsersPerRoom)
+		}
+		ratings[userPtr.name] = userRatings
+	}
+
+	return ratings
+}
+
+func (room *room) memberLatencies() map[string]latencyPayload {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	var latencies map[string]latencyPayload
+	for _, userPtr := range room.members.slice {
+		latency, ok := userPtr.getLatency()
+		if !ok {
+			continue
+		}
+		if latencies == nil {
+			latencies = make(map[string]latencyPayload, maxUsersPerRoom)
+		}
+		latencies[userPtr.name] = latency
+	}
+
+	return latencies
+}
+
+// returns the sequence statistics of members that sent states, keyed by user name
+func (room *room) memberStateStreams() map[string]streamPayload {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	var streams map[string]streamPayload
 	for _, userPtr := range room.members.slice {
-		if userPtr.name == currStatePtr.UserName {
+		if userPtr.isBot || userPtr.stateStream.received == 0 {
 			continue
 		}
+		if streams == nil {
+			streams = make(map[string]streamPayload, maxUsersPerRoom)
+		}
+		streams[userPtr.name] = userPtr.stateStream.payload()
+	}
+
+	return streams
+}
+
+// validates the room settings of payload and fills in defaults for the ones left unset
+func validateRoomPayload(payload *roomPayload) error {
+	if payload.TickRate == 0 {
+		payload.TickRate = defaultTickRate
+	} else if payload.TickRate < minTickRate || maxTickRate < payload.TickRate {
+		return fmt.Errorf("tick rate must be between %v and %v", minTickRate, maxTickRate)
+	}
+
+	err := validateMatchRules(matchRules{goalLimit: payload.GoalLimit, timeLimit: payload.TimeLimit, winByTwo: payload.WinByTwo, suddenDeath: payload.SuddenDeath})
+	if err != nil {
+		return err
+	}
+
+	if payload.PauseBudget == nil {
+		pauseBudget := defaultPauseBudget
+		payload.PauseBudget = &pauseBudget
+	}
+	err = validatePauseSettings(*payload.PauseBudget, payload.PauseTimeout)
+	if err != nil {
+		return err
+	}
+	if payload.PauseTimeout == 0 {
+		payload.PauseTimeout = defaultPauseTimeout
+	}
+
+	if maxPasswordLength < len(payload.Password) {
+		return fmt.Errorf("password cannot be more than %v characters", maxPasswordLength)
+	}
+
+	return nil
+}
 
-		// set the state.isHost field
-		currStatePtr.IsHost = currStatePtr.UserName == room.host.name
+// creates a room described by an already validated payload, with hostPtr as its host and first member
+func createRoom(payload roomPayload, hostPtr *user) (*room, error) {
+	hostPtr.team = payload.Team
+	hostPtr.striker = payload.Striker
+
+	newRoom := room{
+		name:         payload.RoomName,
+		host:         hostPtr,
+		members:      &userArray{slice: make([]*user, 0, maxUsersPerRoom)},
+		spectators:   &userArray{slice: make([]*user, 0, maxSpectatorsPerRoom)},
+		stateChannel: make(chan *state),
+		doneChannel:  make(chan struct{}),
+		tickRate:     payload.TickRate,
+		isPrivate:    payload.IsPrivate || payload.Password != "",
+		rules:        matchRules{goalLimit: payload.GoalLimit, timeLimit: payload.TimeLimit, winByTwo: payload.WinByTwo, suddenDeath: payload.SuddenDeath},
+		pauseBudget:  *payload.PauseBudget,
+		pauseTimeout: time.Duration(payload.PauseTimeout) * time.Second,
+		isRecorded:   payload.Record,
+	}
 
-		err := userPtr.conn.WriteJSON(currStatePtr)
+	// private rooms are protected by a password if the host chose one, otherwise by a generated invite code
+	var err error
+	if payload.Password != "" {
+		passwordHash := sha256.Sum256([]byte(payload.Password))
+		newRoom.passwordHash = passwordHash[:]
+	} else if newRoom.isPrivate {
+		newRoom.inviteCode, err = generateInviteCode()
 		if err != nil {
-			log.Printf("[ERROR] error sending state from user %s to user %s. Reason: %v\n", currStatePtr.UserName, userPtr.name, err)
-		} else {
-			// log.Printf("[INFO] sent state from user %s to user %s\n", currStatePtr.UserName, userPtr.name)
+			return nil, err
 		}
 	}
+
+	err = newRoom.addMember(hostPtr)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rooms.add(&newRoom)
+	if err != nil {
+		return nil, err
+	}
+
+	hostPtr.setRoom(&newRoom, false)
+
+	log.Println("[INFO] created room", newRoom.name)
+	return &newRoom, nil
 }
@@ -66,8 +66,8 @@ func (rooms *roomArray) deleteUsingIdx(idx int) error {
 		return errors.New("invalid index")
 	}
 
-	// close room's state channel
-	close(rooms.slice[idx].stateChannel)
+	// stop room's state consumer
+	close(rooms.slice[idx].doneChannel)
 	log.Printf("[INFO] deleted room %s\n", rooms.slice[idx].name)
 
 	rooms.slice[idx] = rooms.slice[len(rooms.slice)-1]
@@ -84,8 +84,8 @@ func (rooms *roomArray) deleteUsingName(roomName string) error {
 		return errors.New("could not find room to delete using name")
 	}
 
-	// close room's state channel
-	close(rooms.slice[idx].stateChannel)
+	// stop room's state consumer
+	close(rooms.slice[idx].doneChannel)
 
 	rooms.slice[idx] = rooms.slice[len(rooms.slice)-1]
 	rooms.slice = rooms.slice[:len(rooms.slice)-1]
@@ -95,10 +95,19 @@ func (rooms *roomArray) deleteUsingName(roomName string) error {
 }
 
 type joinableRoom struct {
-	RoomName          string `json:"roomName"`
-	CanJoinLeftTeam   bool   `json:"canJoinLeftTeam"`
-	CanJoinRightTeam  bool   `json:"canJoinRightTeam"`
-	AvailableStrikers []int  `json:"availableStrikers"`
+	RoomName          string                    `json:"roomName"`
+	CanJoinLeftTeam   bool                      `json:"canJoinLeftTeam"`
+	CanJoinRightTeam  bool                      `json:"canJoinRightTeam"`
+	AvailableStrikers []int                     `json:"availableStrikers"`
+	SpectatorCount    int                       `json:"spectatorCount"`
+	CanSpectate       bool                      `json:"canSpectate"`
+	GoalLimit         int                       `json:"goalLimit"`
+	TimeLimit         int                       `json:"timeLimit"`
+	WinByTwo          bool                      `json:"winByTwo"`
+	SuddenDeath       bool                      `json:"suddenDeath"`
+	Ratings           map[string]map[string]int `json:"ratings,omitempty"`      // ratings of members with a profile, keyed by user name and then by mode
+	Latencies         map[string]latencyPayload `json:"latencies,omitempty"`    // latencies of connected members, keyed by user name
+	StateStreams      map[string]streamPayload  `json:"stateStreams,omitempty"` // loss and reorder counts of members' states, keyed by user name
 }
 
 func (rooms *roomArray) getJoinableRooms() []*joinableRoom {
@@ -107,9 +116,16 @@ func (rooms *roomArray) getJoinableRooms() []*joinableRoom {
 
 	roomList := make([]*joinableRoom, 0, len(rooms.slice))
 	for _, room := range rooms.slice {
-		leftTeamCount, rightTeamCount := room.getTeamCounts()
+		// private and locked rooms are never advertised
+		isHidden, leftTeamCount, rightTeamCount, rules := room.getListing()
+		if isHidden {
+			continue
+		}
+
+		spectatorCount := room.spectatorCount()
 
-		if leftTeamCount == maxUsersPerTeam && rightTeamCount == maxUsersPerTeam {
+		// full rooms are still listed as long as they can be spectated
+		if leftTeamCount == maxUsersPerTeam && rightTeamCount == maxUsersPerTeam && spectatorCount == maxSpectatorsPerRoom {
 			continue
 		}
 
@@ -118,6 +134,15 @@ func (rooms *roomArray) getJoinableRooms() []*joinableRoom {
 			CanJoinLeftTeam:   leftTeamCount < maxUsersPerTeam,
 			CanJoinRightTeam:  rightTeamCount < maxUsersPerTeam,
 			AvailableStrikers: room.getAvailableStrikers(),
+			SpectatorCount:    spectatorCount,
+			CanSpectate:       spectatorCount < maxSpectatorsPerRoom,
+			GoalLimit:         rules.goalLimit,
+			TimeLimit:         rules.timeLimit,
+			WinByTwo:          rules.winByTwo,
+			SuddenDeath:       rules.suddenDeath,
+			Ratings:           room.memberRatings(),
+			Latencies:         room.memberLatencies(),
+			StateStreams:      room.memberStateStreams(),
 		})
 	}
 
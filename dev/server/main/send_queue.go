@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"goal/codec"
+
+	"github.com/gorilla/websocket"
+)
+
+// queues a state message for user without blocking; a full queue is handled according to stateQueuePolicy
+func (user *user) sendState(data []byte) {
+	user.mu.Lock()
+	stateQueue := user.stateQueue
+	user.mu.Unlock()
+
+	if stateQueue == nil {
+		return
+	}
+
+	for {
+		select {
+		case stateQueue <- data:
+			return
+		default:
+		}
+
+		if stateQueuePolicy == "disconnect" {
+			log.Printf("[ERROR] state queue of user %s is full, disconnecting\n", user.name)
+			user.disconnect()
+			return
+		}
+
+		// drop oldest queued state to make room for the newest one
+		select {
+		case <-stateQueue:
+		default:
+		}
+	}
+}
+
+// queues a control message for user without blocking; control messages are never dropped, so a full queue disconnects user
+func (user *user) sendControl(payload any) error {
+	if user.isBot {
+		return nil
+	}
+
+	user.mu.Lock()
+	controlQueue := user.controlQueue
+	isConnected := user.isConnected
+	user.mu.Unlock()
+
+	if controlQueue == nil {
+		return errors.New("user has no send queue")
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case controlQueue <- data:
+		return nil
+	default:
+	}
+
+	// nothing drains the queue of a suspended user, who gets fresh queues when they resume
+	if !isConnected {
+		return errors.New("control queue of disconnected user is full")
+	}
+
+	log.Printf("[ERROR] control queue of user %s is full, disconnecting\n", user.name)
+	user.disconnect()
+	return errors.New("control queue is full")
+}
+
+// closes user's connection, which ends their read loop and triggers cleanupPostDisconnect()
+func (user *user) disconnect() {
+	user.mu.Lock()
+	conn := user.conn
+	user.mu.Unlock()
+
+	if conn == nil {
+		return
+	}
+
+	err := conn.Close()
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("[ERROR] error while disconnecting user %s. Reason: %v\n", user.name, err)
+	}
+}
+
+// drains the send queues user has for conn, giving control messages priority over state messages; messages are queued
+// as json and converted to the binary codec on the way out if protocol is not 0
+func writeQueued(user *user, conn *websocket.Conn, protocol int, terminateChannel chan struct{}, waitGroup *sync.WaitGroup) {
+	defer waitGroup.Done()
+
+	stateQueue, controlQueue, ok := user.getQueues(conn)
+	if !ok {
+		return // a resumed session took over user before this connection got to write anything
+	}
+
+	for {
+		var data []byte
+
+		select {
+		case <-terminateChannel:
+			return
+		case data = <-controlQueue:
+		default:
+			select {
+			case <-terminateChannel:
+				return
+			case data = <-controlQueue:
+			case data = <-stateQueue:
+			}
+		}
+
+		messageType := websocket.TextMessage
+		if protocol != 0 {
+			var err error
+			data, err = codec.Encode(protocol, data)
+			if err != nil {
+				log.Printf("[ERROR] error encoding message to user %s. Reason: %v\n", user.name, err)
+				continue
+			}
+			messageType = websocket.BinaryMessage
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(webSocketWriteWait * time.Second))
+		err := conn.WriteMessage(messageType, data)
+		if err != nil {
+			log.Printf("[ERROR] error writing to user %s. Reason: %v\n", user.name, err)
+			conn.Close() // not user.disconnect(), as user may already have resumed on a newer connection
+			return
+		}
+	}
+}
@@ -1,5 +1,11 @@
 package main
 
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
 type state struct {
 	Channel    string `json:"channel"`
 	UserName   string `json:"userName"`
@@ -16,4 +22,133 @@ type state struct {
 	PuckYVel   int    `json:"puckYVel"`
 	LeftScore  int    `json:"leftScore"`
 	RightScore int    `json:"rightScore"`
+	Seq        uint64 `json:"seq"`        // per-sender sequence number, stamped by the server for clients that leave it unset
+	ServerTime int64  `json:"serverTime"` // unix time in milliseconds at which the server received the state
+}
+
+type snapshot struct {
+	Channel string   `json:"channel"`
+	Tick    uint64   `json:"tick"`
+	Phase   string   `json:"phase"`
+	States  []*state `json:"states"`
+}
+
+var errInvalidState = errors.New("invalid state")
+
+// returns false without an error for stale states, which are dropped silently since they are expected under packet loss
+func (room *room) acceptState(userPtr *user, currStatePtr *state) (bool, error) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	err := room.validateState(userPtr, currStatePtr)
+	if err != nil {
+		return false, fmt.Errorf("%w: %w", errInvalidState, err)
+	}
+
+	if !room.sequenceState(userPtr, currStatePtr) {
+		return false, nil
+	}
+	room.recordStrikerFrame(userPtr, currStatePtr)
+	return true, nil
+}
+
+// only call while holding room.mu; rejects states that don't belong to userPtr's slot in room, so that a client can't move another member's striker
+func (room *room) validateState(userPtr *user, currStatePtr *state) error {
+	if currStatePtr.UserName != userPtr.name {
+		return errors.New("state must carry the sender's user name")
+	} else if currStatePtr.Team != userPtr.team || currStatePtr.Striker != userPtr.striker {
+		return fmt.Errorf("state must carry the sender's team %s and striker %d", userPtr.team, userPtr.striker)
+	}
+
+	// positions are fractions of the board, velocities are bounded by the board's size
+	if !isInRange(currStatePtr.PlayerXPos, 0, truncateFloatFactor) || !isInRange(currStatePtr.PlayerYPos, 0, truncateFloatFactor) {
+		return errors.New("striker position is out of the board")
+	} else if !isInRange(currStatePtr.PlayerXVel, -truncateFloatFactor, truncateFloatFactor) || !isInRange(currStatePtr.PlayerYVel, -truncateFloatFactor, truncateFloatFactor) {
+		return errors.New("striker velocity is out of range")
+	}
+
+	return nil
+}
+
+// tracks the sequence numbers of the states a member sent since joining; only access while holding room.mu
+type stateStream struct {
+	firstSeq   uint64
+	latestSeq  uint64 // latest forwarded sequence number
+	window     uint64 // bit i is set if state latestSeq-i arrived
+	received   int
+	lost       int // gaps in the sequence that haven't been filled by late states
+	reordered  int // states that arrived after a later one and were dropped
+	duplicated int
+	skipped    int    // states that jumped more than 64 past latestSeq, the gap they leave is not counted as lost
+	skippedSeq uint64 // latest skipped sequence number
+}
+
+type streamPayload struct {
+	Received   int `json:"received"`
+	Lost       int `json:"lost"`
+	Reordered  int `json:"reordered"`
+	Duplicated int `json:"duplicated"`
+	Skipped    int `json:"skipped"`
+}
+
+// only call while holding room.mu; stamps currStatePtr and returns false if it must be dropped because a later state of userPtr was already forwarded
+func (room *room) sequenceState(userPtr *user, currStatePtr *state) bool {
+	currStatePtr.ServerTime = time.Now().UnixMilli()
+	if currStatePtr.Seq == 0 {
+		currStatePtr.Seq = userPtr.stateStream.latestSeq + 1
+	}
+
+	return userPtr.stateStream.track(currStatePtr.Seq)
+}
+
+func (stream *stateStream) track(seq uint64) bool {
+	stream.received++
+
+	if stream.latestSeq < seq {
+		gap := seq - stream.latestSeq
+		if stream.latestSeq == 0 {
+			stream.firstSeq = seq
+		} else if 64 < gap {
+			// the states in between were dropped before reaching the stream or never existed, so start over
+			// from seq once the next state confirms the jump, instead of counting the gap as lost
+			stream.skipped++
+			isConfirmed := seq == stream.skippedSeq+1
+			stream.skippedSeq = seq
+			if !isConfirmed {
+				return false
+			}
+			stream.firstSeq = seq
+		} else {
+			stream.lost += int(gap - 1)
+		}
+
+		if gap < 64 {
+			stream.window = stream.window<<gap | 1
+		} else {
+			stream.window = 1
+		}
+		stream.latestSeq = seq
+		return true
+	}
+
+	age := stream.latestSeq - seq
+	if age < 64 && stream.window&(1<<age) != 0 {
+		stream.duplicated++
+		return false
+	}
+
+	stream.reordered++
+	if age < 64 && stream.firstSeq < seq {
+		stream.window |= 1 << age
+		stream.lost-- // this state was counted as lost when the gap was seen
+	}
+	return false
+}
+
+func (stream *stateStream) payload() streamPayload {
+	return streamPayload{Received: stream.received, Lost: stream.lost, Reordered: stream.reordered, Duplicated: stream.duplicated, Skipped: stream.skipped}
+}
+
+func isInRange(value int, low int, high int) bool {
+	return low <= value && value <= high
 }
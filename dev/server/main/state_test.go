@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestStateStreamTrack(t *testing.T) {
+	tests := []struct {
+		name  string
+		seqs  []uint64
+		want  []bool // whether each state is forwarded
+		stats streamPayload
+	}{
+		{"in order", []uint64{1, 2, 3}, []bool{true, true, true}, streamPayload{Received: 3}},
+		{"first seq need not be 1", []uint64{40, 41}, []bool{true, true}, streamPayload{Received: 2}},
+		{"gap", []uint64{1, 2, 5}, []bool{true, true, true}, streamPayload{Received: 3, Lost: 2}},
+		{"late state fills gap", []uint64{1, 3, 2}, []bool{true, true, false}, streamPayload{Received: 3, Reordered: 1}},
+		{"duplicate", []uint64{1, 2, 2, 1}, []bool{true, true, false, false}, streamPayload{Received: 4, Duplicated: 2}},
+		{"late state before the first", []uint64{5, 4}, []bool{true, false}, streamPayload{Received: 2, Reordered: 1}},
+		{"largest counted gap", []uint64{1, 65}, []bool{true, true}, streamPayload{Received: 2, Lost: 63}},
+		{"jump is skipped", []uint64{1, 66, 2}, []bool{true, false, true}, streamPayload{Received: 3, Skipped: 1}},
+		{"confirmed jump", []uint64{1, 100, 101, 102}, []bool{true, false, true, true}, streamPayload{Received: 4, Skipped: 2}},
+		{"state before a confirmed jump", []uint64{1, 100, 101, 99}, []bool{true, false, true, false}, streamPayload{Received: 4, Reordered: 1, Skipped: 2}},
+		{"huge jump", []uint64{1, math.MaxUint64}, []bool{true, false}, streamPayload{Received: 2, Skipped: 1}},
+	}
+
+	for _, test := range tests {
+		var stream stateStream
+		for idx, seq := range test.seqs {
+			if got := stream.track(seq); got != test.want[idx] {
+				t.Errorf("%s: track(%d) = %v, want %v", test.name, seq, got, test.want[idx])
+			}
+		}
+
+		if got := stream.payload(); got != test.stats {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.stats)
+		}
+	}
+}
+
+func TestSequenceStateStampsMissingSeq(t *testing.T) {
+	room := &room{}
+	userPtr := &user{name: "jomin"}
+
+	for want := uint64(1); want <= 3; want++ {
+		currState := state{UserName: userPtr.name}
+		if !room.sequenceState(userPtr, &currState) {
+			t.Fatalf("state %d was dropped", want)
+		} else if currState.Seq != want || currState.ServerTime == 0 {
+			t.Fatalf("got seq %d and server time %d, want seq %d and a server time", currState.Seq, currState.ServerTime, want)
+		}
+	}
+}
@@ -8,11 +8,11 @@ func createTestRooms() {
 	slice[0] = &testUser1
 	members := userArray{slice: slice}
 
-	rooms.add(&room{name: "testroom_1", host: &testUser1, leftTeamCount: 0, rightTeamCount: 0, members: &members})
-	rooms.add(&room{name: "testroom_2", host: &testUser1, leftTeamCount: 0, rightTeamCount: 0, members: &members})
-	rooms.add(&room{name: "testroom_3", host: &testUser1, leftTeamCount: 0, rightTeamCount: 0, members: &members})
-	rooms.add(&room{name: "testroom_4", host: &testUser1, leftTeamCount: 0, rightTeamCount: 0, members: &members})
-	rooms.add(&room{name: "testroom_5", host: &testUser1, leftTeamCount: 0, rightTeamCount: 0, members: &members})
-	rooms.add(&room{name: "testroom_6", host: &testUser1, leftTeamCount: 0, rightTeamCount: 0, members: &members})
-	rooms.add(&room{name: "testroom_7", host: &testUser1, leftTeamCount: 0, rightTeamCount: 0, members: &members})
+	rooms.add(&room{name: "testroom_1", host: &testUser1, leftTeamCount: 0, rightTeamCount: 0, members: &members, spectators: &userArray{}, tickRate: defaultTickRate})
+	rooms.add(&room{name: "testroom_2", host: &testUser1, leftTeamCount: 0, rightTeamCount: 0, members: &members, spectators: &userArray{}, tickRate: defaultTickRate})
+	rooms.add(&room{name: "testroom_3", host: &testUser1, leftTeamCount: 0, rightTeamCount: 0, members: &members, spectators: &userArray{}, tickRate: defaultTickRate})
+	rooms.add(&room{name: "testroom_4", host: &testUser1, leftTeamCount: 0, rightTeamCount: 0, members: &members, spectators: &userArray{}, tickRate: defaultTickRate})
+	rooms.add(&room{name: "testroom_5", host: &testUser1, leftTeamCount: 0, rightTeamCount: 0, members: &members, spectators: &userArray{}, tickRate: defaultTickRate})
+	rooms.add(&room{name: "testroom_6", host: &testUser1, leftTeamCount: 0, rightTeamCount: 0, members: &members, spectators: &userArray{}, tickRate: defaultTickRate})
+	rooms.add(&room{name: "testroom_7", host: &testUser1, leftTeamCount: 0, rightTeamCount: 0, members: &members, spectators: &userArray{}, tickRate: defaultTickRate})
 }
@@ -1,19 +1,63 @@
 package main
 
 import (
+	"crypto/subtle"
 	"errors"
 	"fmt"
+	"log"
+	"net"
 	"sync"
 
 	"github.com/gorilla/websocket"
 )
 
 type user struct {
+	mu      sync.Mutex
 	name    string
 	conn    *websocket.Conn
-	room    *room
 	team    string
 	striker int
+	// room; only access while holding user.mu, as others move user in and out of rooms, e.g. a host who kicks them
+	room        *room
+	isSpectator bool
+	// bot
+	isBot         bool // bots are server-controlled members without a connection
+	botDifficulty string
+	// outgoing messages, drained by the connection's writer; only access while holding user.mu, as a resumed
+	// session gets queues of its own, so that the writer of the previous connection can't take its messages
+	stateQueue   chan []byte
+	controlQueue chan []byte
+	// chat
+	chatLimiter rateLimiter
+	// delta snapshots; only access while holding room.mu once the user is in a room
+	wantsDeltas  bool
+	ackedTick    uint64 // latest snapshot the user applied
+	keyframeTick uint64 // latest full snapshot sent to the user
+	stateStream  stateStream
+	// lag compensation; only access while holding room.mu
+	strikerFrames [strikerHistorySize]strikerFrame // striker of recent states, indexed by seq modulo strikerHistorySize
+	// latency; only access while holding user.mu
+	latency latencyStats
+	// session
+	sessionToken   string
+	isConnected    bool
+	isExpired      bool
+	connGeneration int
+}
+
+// returns the room user is in, if any, and whether they are spectating it
+func (user *user) getRoom() (*room, bool) {
+	user.mu.Lock()
+	defer user.mu.Unlock()
+
+	return user.room, user.isSpectator
+}
+
+func (user *user) setRoom(room *room, isSpectator bool) {
+	user.mu.Lock()
+	user.room = room
+	user.isSpectator = isSpectator
+	user.mu.Unlock()
 }
 
 type userArray struct {
@@ -46,6 +90,8 @@ func (users *userArray) add(newUser *user) error {
 	err := validateUserName(newUser.name)
 	if err != nil {
 		return err
+	} else if isBotName(newUser.name) && !newUser.isBot {
+		return fmt.Errorf("user names starting with %q are reserved for bots", botNamePrefix)
 	}
 
 	users.mu.Lock()
@@ -106,6 +152,89 @@ func (users *userArray) deleteUsingName(userName string) error {
 	return nil
 }
 
+// finds the user with matching name and session token, and hands them the new connection; a user who still seems
+// connected is taken over, since the server may take up to webSocketTimeout seconds to notice that a connection died
+func (users *userArray) resume(userName string, sessionToken string, conn *websocket.Conn) (*user, error) {
+	users.mu.Lock()
+	defer users.mu.Unlock()
+
+	idx, err := findUserIdx(users.slice, userName)
+	if err != nil || subtle.ConstantTimeCompare([]byte(users.slice[idx].sessionToken), []byte(sessionToken)) != 1 {
+		return nil, errors.New("invalid session token")
+	}
+
+	// taken from only once the token proved the name is theirs, so that others can't use up the limit of the name
+	err = rateLimitHandshake(userName)
+	if err != nil {
+		return nil, err
+	}
+
+	userPtr := users.slice[idx]
+	userPtr.mu.Lock()
+	defer userPtr.mu.Unlock()
+
+	if userPtr.isExpired {
+		return nil, errors.New("session expired")
+	}
+
+	// closing the old connection ends its read loop, whose cleanup leaves userPtr alone as it no longer owns them
+	if userPtr.isConnected && userPtr.conn != nil {
+		err := userPtr.conn.Close()
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("[ERROR] error while closing the previous connection of user %s. Reason: %v\n", userName, err)
+		}
+	}
+
+	userPtr.conn = conn
+	userPtr.isConnected = true
+	userPtr.connGeneration++
+	userPtr.latency = latencyStats{} // the new connection may take a different route
+
+	// messages queued for the previous connection are stale, and are left to its writer until it notices the closed connection
+	userPtr.stateQueue = make(chan []byte, stateQueueSize)
+	userPtr.controlQueue = make(chan []byte, controlQueueSize)
+
+	return userPtr, nil
+}
+
+// returns the send queues for conn to drain, or false if user has already moved on to a newer connection
+func (user *user) getQueues(conn *websocket.Conn) (chan []byte, chan []byte, bool) {
+	user.mu.Lock()
+	defer user.mu.Unlock()
+
+	if user.conn != conn {
+		return nil, nil, false
+	}
+
+	return user.stateQueue, user.controlQueue, true
+}
+
+// marks user as disconnected if conn is still their connection, and returns the generation of the connection that was lost
+func (user *user) suspend(conn *websocket.Conn) (int, bool) {
+	user.mu.Lock()
+	defer user.mu.Unlock()
+
+	if user.conn != conn {
+		return 0, false
+	}
+
+	user.isConnected = false
+	return user.connGeneration, true
+}
+
+// returns true if user has not resumed their session since the connection of the given generation was lost; such a user can no longer resume
+func (user *user) expire(generation int) bool {
+	user.mu.Lock()
+	defer user.mu.Unlock()
+
+	if user.isConnected || user.connGeneration != generation {
+		return false
+	}
+
+	user.isExpired = true
+	return true
+}
+
 // util functions: not meant to be used outside this file
 func validateUserName(userName string) error {
 	if userName == "" {
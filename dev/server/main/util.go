@@ -1,17 +1,20 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"log"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-func pingPeriodically(conn *websocket.Conn, terminateChannel chan struct{}, waitGroup *sync.WaitGroup) {
+func pingPeriodically(conn *websocket.Conn, pendingPing *atomic.Int64, terminateChannel chan struct{}, waitGroup *sync.WaitGroup) {
 	defer waitGroup.Done()
-	ticker := time.NewTicker((webSocketTimeout - 6) * time.Second)
+	ticker := time.NewTicker(pingInterval * time.Second)
 	defer ticker.Stop()
 
 	for {
@@ -21,7 +24,7 @@ func pingPeriodically(conn *websocket.Conn, terminateChannel chan struct{}, wait
 		case <-ticker.C:
 			err := conn.WriteControl(
 				websocket.PingMessage,
-				nil,
+				nextPingPayload(pendingPing),
 				time.Now().Add(10*time.Second),
 			)
 			if err != nil {
@@ -32,19 +35,55 @@ func pingPeriodically(conn *websocket.Conn, terminateChannel chan struct{}, wait
 	}
 }
 
-func cleanupPostDisconnect(currUser *user, terminateChannel chan struct{}, waitGroup *sync.WaitGroup) {
-	close(terminateChannel) // signal pingPong goroutine to terminate
-	waitGroup.Wait()        // wait for pingPong goroutine to terminate
+func cleanupPostDisconnect(currUser *user, conn *websocket.Conn, terminateChannel chan struct{}, waitGroup *sync.WaitGroup) {
+	close(terminateChannel) // signal pingPong and writer goroutines to terminate
+	waitGroup.Wait()        // wait for pingPong and writer goroutines to terminate
 
 	if currUser.name == "" {
 		return
 	}
 
+	// a resumed session may have taken over currUser, who then belongs to the newer connection
+	generation, isOwner := currUser.suspend(conn)
+	if !isOwner {
+		log.Printf("[INFO] previous connection of user %s closed after their session was resumed\n", currUser.name)
+		return
+	}
+
+	roomPtr, isSpectator := currUser.getRoom()
+	if roomPtr == nil || isSpectator {
+		deleteUser(currUser)
+		return
+	}
+
+	// hold user's slot in their room for a grace period, so that they can resume their session using their session token
+	roomPtr.broadcastControl(memberConnectivityPayload{Channel: "memberDisconnected", UserName: currUser.name, GracePeriod: reconnectGracePeriod})
+	log.Printf("[INFO] holding slot of user %s in room %s for %v seconds\n", currUser.name, roomPtr.name, reconnectGracePeriod)
+
+	time.AfterFunc(reconnectGracePeriod*time.Second, func() {
+		if !currUser.expire(generation) {
+			return
+		}
+
+		log.Printf("[INFO] session of user %s expired\n", currUser.name)
+		deleteUser(currUser)
+	})
+}
+
+func deleteUser(currUser *user) {
+	matchmaking.dequeue(currUser)
+
 	// delete user from their room
-	if currUser.room != nil {
-		err := currUser.room.deleteMember(currUser)
+	roomPtr, isSpectator := currUser.getRoom()
+	if roomPtr != nil && isSpectator {
+		err := roomPtr.deleteSpectator(currUser)
 		if err != nil {
-			log.Printf("[ERROR] error while deleting user %s from room %s. Reason: %v\n", currUser.name, currUser.room.name, err)
+			log.Printf("[ERROR] error while deleting spectator %s from room %s. Reason: %v\n", currUser.name, roomPtr.name, err)
+		}
+	} else if roomPtr != nil {
+		err := roomPtr.deleteMember(currUser)
+		if err != nil {
+			log.Printf("[ERROR] error while deleting user %s from room %s. Reason: %v\n", currUser.name, roomPtr.name, err)
 		}
 	}
 
@@ -53,6 +92,32 @@ func cleanupPostDisconnect(currUser *user, terminateChannel chan struct{}, waitG
 	log.Printf("[INFO] deleted user %s\n", currUser.name)
 }
 
+func generateToken(length int) (string, error) {
+	bytes := make([]byte, length)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(bytes), nil
+}
+
+func generateInviteCode() (string, error) {
+	const alphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // omits characters that are easily confused, like 0/O and 1/I
+
+	bytes := make([]byte, inviteCodeLength)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range bytes {
+		bytes[i] = alphabet[int(bytes[i])%len(alphabet)]
+	}
+
+	return string(bytes), nil
+}
+
 func rotateLogs(filename string) {
 	for {
 		// wait for 1 week